@@ -0,0 +1,187 @@
+package main
+
+import (
+	"math"
+	"time"
+
+	"github.com/jsmithedin/overmyhouse/pkg/modes"
+)
+
+// targetState is what the autopilot has been asked to do, from extended
+// squitter type 29 subtype 1
+type targetState struct {
+	selectedAltitude    timedValue // ft
+	selectedAltitudeFMS bool       // set by the FMS rather than the MCP/FCU
+	baroSetting         timedValue // mb
+	selectedHeading     timedValue // degrees
+	selectedTrack       bool       // the selected heading is a track, version 1 only
+
+	autopilot    bool
+	vnav         bool
+	altitudeHold bool
+	approach     bool
+	lnav         bool
+	modesUpdated time.Time
+}
+
+// operationalStatus is what an aircraft reports about its own ADS-B
+// equipment, from extended squitter type 31
+type operationalStatus struct {
+	nicSupplementA  bool
+	nacp            uint8
+	sil             uint8
+	silSupplement   bool // version 2 only, probability is per sample rather than per hour
+	gva             uint8
+	tcasOperational bool
+	es1090In        bool
+	uatIn           bool
+	updated         time.Time
+}
+
+// positionIntegrity is how far out an aircraft's airborne positions might be.
+// Version 0 ADS-B gives a NUCp, later versions a NIC and a containment radius
+// worked out from the type code and the NIC supplements.
+type positionIntegrity struct {
+	nucp uint8
+	nic  uint8
+	// Containment radius in meters, math.MaxFloat64 if unknown
+	rc float64
+}
+
+// Containment radii by NIC, in meters
+const (
+	nm = 1852.0
+
+	rcNIC11 = 7.5
+	rcNIC10 = 25
+	rcNIC9  = 75
+	rcNIC8  = 0.1 * nm
+	rcNIC7  = 0.2 * nm
+	rcNIC6  = 0.5 * nm
+	rcNIC5  = 1 * nm
+	rcNIC4  = 2 * nm
+	rcNIC3  = 4 * nm
+	rcNIC2  = 8 * nm
+	rcNIC1  = 20 * nm
+)
+
+// applyPositionIntegrity works out the integrity of an airborne position
+// message the way the aircraft's ADS-B version defines it
+func applyPositionIntegrity(message *modes.AirbornePosition, aircraft *aircraftData) {
+	typeCode := message.TypeCode
+	version := aircraft.adsbVersion
+
+	if version == 0 {
+		nucp := uint8(0)
+		switch {
+		case typeCode >= 9 && typeCode <= 18:
+			nucp = 18 - typeCode
+		case typeCode == 20 || typeCode == 21:
+			nucp = 29 - typeCode
+		}
+		aircraft.integrity = positionIntegrity{nucp: nucp, rc: math.MaxFloat64}
+		return
+	}
+
+	// Version 1 only has supplement A, in the operational status. Version 2
+	// adds supplement B in the position, and some combinations of the two
+	// aren't defined.
+	nicA := aircraft.opStatus.nicSupplementA
+	nicB := version >= 2 && message.NICSupplementB
+	onlyA := version == 1 || nicA == nicB
+
+	integrity := positionIntegrity{rc: math.MaxFloat64}
+	switch {
+	case typeCode == 9 || typeCode == 20:
+		integrity.nic, integrity.rc = 11, rcNIC11
+	case typeCode == 10 || typeCode == 21:
+		integrity.nic, integrity.rc = 10, rcNIC10
+	case typeCode == 11 && nicA && onlyA:
+		integrity.nic, integrity.rc = 9, rcNIC9
+	case typeCode == 11 && !nicA && !nicB:
+		integrity.nic, integrity.rc = 8, rcNIC8
+	case typeCode == 12:
+		integrity.nic, integrity.rc = 7, rcNIC7
+	case typeCode == 13 && !nicA && nicB:
+		integrity.nic, integrity.rc = 6, 0.3*nm
+	case typeCode == 13 && !nicA:
+		integrity.nic, integrity.rc = 6, rcNIC6
+	case typeCode == 13 && onlyA:
+		integrity.nic, integrity.rc = 6, 0.6*nm
+	case typeCode == 14:
+		integrity.nic, integrity.rc = 5, rcNIC5
+	case typeCode == 15:
+		integrity.nic, integrity.rc = 4, rcNIC4
+	case typeCode == 16 && nicA && onlyA:
+		integrity.nic, integrity.rc = 3, rcNIC3
+	case typeCode == 16 && !nicA && !nicB:
+		integrity.nic, integrity.rc = 2, rcNIC2
+	case typeCode == 17:
+		integrity.nic, integrity.rc = 1, rcNIC1
+	}
+	aircraft.integrity = integrity
+}
+
+// applyTargetState copies the autopilot state onto the aircraft
+func applyTargetState(message *modes.TargetState, aircraft *aircraftData, now time.Time) {
+	// Subtype 0 is only defined for version 1, from anything that has told us
+	// it is another version the bits mean something else
+	if message.Version == 1 && !aircraft.opStatus.updated.IsZero() && aircraft.adsbVersion != 1 {
+		return
+	}
+
+	state := &aircraft.targetState
+
+	if message.SelectedAltitude != math.MaxFloat64 {
+		state.selectedAltitude.set(message.SelectedAltitude, now)
+		state.selectedAltitudeFMS = message.SelectedAltitudeFMS
+	}
+	if message.BaroSetting != math.MaxFloat64 {
+		state.baroSetting.set(message.BaroSetting, now)
+	}
+	if message.SelectedHeading != math.MaxFloat64 {
+		state.selectedHeading.set(message.SelectedHeading, now)
+		state.selectedTrack = message.SelectedTrack
+	}
+
+	if message.ModesValid {
+		state.autopilot = message.Autopilot
+		state.vnav = message.VNAV
+		state.altitudeHold = message.AltitudeHold
+		state.approach = message.Approach
+		state.lnav = message.LNAV
+		state.modesUpdated = now
+	}
+
+	if message.TCASKnown {
+		aircraft.opStatus.tcasOperational = message.TCASOperational
+	}
+}
+
+// applyOperationalStatus copies what the aircraft says about its equipment
+// onto it, leaving alone anything its ADS-B version doesn't report
+func applyOperationalStatus(message *modes.OperationalStatus, aircraft *aircraftData, now time.Time) {
+	aircraft.adsbVersion = message.Version
+
+	status := &aircraft.opStatus
+	if message.Version >= 1 {
+		// Version 0 didn't have these, the bits were reserved
+		status.nicSupplementA = message.NICSupplementA
+		status.nacp = message.NACp
+		status.sil = message.SIL
+	}
+	if message.TCASKnown {
+		status.tcasOperational = message.TCASOperational
+	}
+
+	if message.Version >= 2 {
+		status.es1090In = message.ES1090In
+		status.silSupplement = message.SILSupplement
+		if !message.Surface {
+			status.gva = message.GVA
+		}
+		status.uatIn = message.UATIn
+	}
+
+	status.updated = now
+}
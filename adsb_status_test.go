@@ -0,0 +1,135 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/jsmithedin/overmyhouse/pkg/modes"
+)
+
+func Test_applyTargetState(t *testing.T) {
+	testAircraft := newAircraftData(0xA05629, false)
+	decodeInto(t, []byte{141, 160, 86, 41, 234, 33, 72, 92, 191, 63, 140, 173, 174, 235}, &testAircraft)
+
+	state := testAircraft.targetState
+	if state.selectedAltitude.value != 16992 || state.selectedAltitudeFMS {
+		t.Fatalf("expected: 16992 MCP, got: %v FMS %v", state.selectedAltitude.value, state.selectedAltitudeFMS)
+	}
+	if math.Abs(state.baroSetting.value-1012.8) > 0.01 {
+		t.Fatalf("expected: 1012.8, got: %v", state.baroSetting.value)
+	}
+	if math.Abs(state.selectedHeading.value-66.8) > 0.01 {
+		t.Fatalf("expected: 66.8, got: %v", state.selectedHeading.value)
+	}
+	if !state.autopilot || !state.vnav || state.altitudeHold || state.approach || !state.lnav {
+		t.Fatalf("expected autopilot, VNAV and LNAV, got %+v", state)
+	}
+	if state.modesUpdated.IsZero() || state.selectedAltitude.updated.IsZero() {
+		t.Fatalf("expected update times to be set")
+	}
+	if !testAircraft.opStatus.tcasOperational {
+		t.Fatalf("expected TCAS to be operational")
+	}
+}
+
+func Test_applyOperationalStatus(t *testing.T) {
+	now := time.Now()
+	testAircraft := newAircraftData(0x4840D6, false)
+
+	applyOperationalStatus(&modes.OperationalStatus{Version: 2, NACp: 9, SIL: 3, GVA: 2,
+		TCASKnown: true, TCASOperational: true, SILSupplement: true, ES1090In: true, UATIn: true}, &testAircraft, now)
+
+	status := testAircraft.opStatus
+	if testAircraft.adsbVersion != 2 || status.nacp != 9 || status.sil != 3 || status.gva != 2 {
+		t.Fatalf("expected: 2 9 3 2, got: %v %+v", testAircraft.adsbVersion, status)
+	}
+	if !status.tcasOperational || !status.silSupplement || !status.es1090In || !status.uatIn ||
+		!status.updated.Equal(now) {
+		t.Fatalf("expected TCAS, SIL supplement, 1090ES IN and UAT IN, got: %+v", status)
+	}
+
+	// Version 0 says nothing about TCAS, NACp, SIL or the version 2 fields, so they are kept
+	applyOperationalStatus(&modes.OperationalStatus{Version: 0, NACp: 8, SIL: 2}, &testAircraft, now)
+
+	status = testAircraft.opStatus
+	if testAircraft.adsbVersion != 0 || status.nacp != 9 || status.sil != 3 {
+		t.Fatalf("expected: 0 9 3, got: %v %+v", testAircraft.adsbVersion, status)
+	}
+	if !status.tcasOperational || status.gva != 2 || !status.es1090In || !status.uatIn {
+		t.Fatalf("expected TCAS, GVA, 1090ES IN and UAT IN to be kept, got: %+v", status)
+	}
+}
+
+func Test_applyTargetStateV1(t *testing.T) {
+	now := time.Now()
+	message := &modes.TargetState{Version: 1, SelectedAltitude: 35000, BaroSetting: math.MaxFloat64,
+		SelectedHeading: 270, SelectedTrack: true}
+
+	tests := []struct {
+		name    string
+		version uint8
+		status  bool
+		applied bool
+	}{
+		{name: "version unknown", applied: true},
+		{name: "version 1", version: 1, status: true, applied: true},
+		{name: "version 2", version: 2, status: true, applied: false},
+	}
+
+	for _, tc := range tests {
+		testAircraft := newAircraftData(0x4840D6, false)
+		testAircraft.adsbVersion = tc.version
+		testAircraft.opStatus.tcasOperational = true
+		if tc.status {
+			testAircraft.opStatus.updated = now
+		}
+
+		applyTargetState(message, &testAircraft, now)
+
+		state := testAircraft.targetState
+		if applied := state.selectedAltitude.value == 35000; applied != tc.applied {
+			t.Fatalf("%s: expected: %v, got: %+v", tc.name, tc.applied, state)
+		}
+		if tc.applied && (state.selectedHeading.value != 270 || !state.selectedTrack) {
+			t.Fatalf("%s: expected a selected track of 270, got: %+v", tc.name, state)
+		}
+		if !testAircraft.opStatus.tcasOperational {
+			t.Fatalf("%s: expected TCAS to be left alone", tc.name)
+		}
+	}
+}
+
+func Test_applyPositionIntegrity(t *testing.T) {
+	tests := []struct {
+		name     string
+		version  uint8
+		typeCode uint8
+		nicA     bool
+		nicB     bool
+		want     positionIntegrity
+	}{
+		{name: "version 0", version: 0, typeCode: 11, want: positionIntegrity{nucp: 7, rc: math.MaxFloat64}},
+		{name: "version 0 GNSS", version: 0, typeCode: 21, want: positionIntegrity{nucp: 8, rc: math.MaxFloat64}},
+		{name: "version 1 without supplement", version: 1, typeCode: 11, want: positionIntegrity{nic: 8, rc: 0.1 * 1852}},
+		{name: "version 1 with supplement A", version: 1, typeCode: 11, nicA: true, want: positionIntegrity{nic: 9, rc: 75}},
+		// Version 1 didn't have supplement B, the bit is ignored
+		{name: "version 1 ignores B", version: 1, typeCode: 13, nicA: true, nicB: true, want: positionIntegrity{nic: 6, rc: 0.6 * 1852}},
+		{name: "version 2 with both", version: 2, typeCode: 11, nicA: true, nicB: true, want: positionIntegrity{nic: 9, rc: 75}},
+		{name: "version 2 with only B", version: 2, typeCode: 13, nicB: true, want: positionIntegrity{nic: 6, rc: 0.3 * 1852}},
+		{name: "version 2 undefined", version: 2, typeCode: 11, nicA: true, want: positionIntegrity{rc: math.MaxFloat64}},
+		{name: "version 2 low integrity", version: 2, typeCode: 16, want: positionIntegrity{nic: 2, rc: 8 * 1852}},
+		{name: "no integrity", version: 2, typeCode: 18, want: positionIntegrity{rc: math.MaxFloat64}},
+	}
+
+	for _, tc := range tests {
+		testAircraft := newAircraftData(0x4840D6, false)
+		testAircraft.adsbVersion = tc.version
+		testAircraft.opStatus.nicSupplementA = tc.nicA
+
+		applyPositionIntegrity(&modes.AirbornePosition{TypeCode: tc.typeCode, NICSupplementB: tc.nicB}, &testAircraft)
+		if testAircraft.integrity != tc.want {
+			t.Fatalf("%s: expected: %+v, got: %+v", tc.name, tc.want, testAircraft.integrity)
+		}
+	}
+}
@@ -4,8 +4,12 @@ import (
 	"fmt"
 	"math"
 	"sort"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
+
+	"github.com/jsmithedin/overmyhouse/pkg/modes"
 )
 
 type aircraftData struct {
@@ -13,78 +17,400 @@ type aircraftData struct {
 
 	callsign string
 
-	eRawLat uint32
-	eRawLon uint32
-	oRawLat uint32
-	oRawLon uint32
+	eRawLat  uint32
+	eRawLon  uint32
+	eRawTime time.Time
+	oRawLat  uint32
+	oRawLon  uint32
+	oRawTime time.Time
+
+	// Consecutive decoded positions rejected as implausible
+	posRejects int
 
 	latitude  float64
 	longitude float64
 	altitude  int32
 
-	lastPing time.Time
-	lastPos  time.Time
+	// Meters and degrees from the receiver, worked out when the position changes
+	distance float64
+	bearing  float64
+
+	groundSpeed  float64
+	track        float64
+	airspeed     int32
+	airspeedTrue bool
+	heading      float64
+	vertRate     int32
+	vertRateGNSS bool
+	gnssBaroDiff int32
+
+	lastPing     time.Time
+	lastPos      time.Time
+	lastSquitter time.Time
+
+	mlat     bool
+	onGround bool
+
+	squawk    uint16
+	emergency uint8
+
+	// Emitter category as set and number, e.g. 0xA5 for a heavy, 0 if not reported
+	category uint8
+
+	commB       commBData
+	targetState targetState
+	opStatus    operationalStatus
+
+	// ADS-B version the aircraft is transmitting, 0 until it sends an operational status message
+	adsbVersion uint8
+	// Of the last airborne position, depends on adsbVersion
+	integrity positionIntegrity
+
+	source        modes.Source
+	sourceUpdated time.Time
+
+	// Where the aircraft has been, only reachable through KnownAircraft.Trail
+	history trackHistory
+}
+
+// Targets that aren't identified by an ICAO address are kept apart from those
+// that are by setting one of these bits above the 24 bit address.
+const (
+	// Mode A/C replies carry no address, so they are tracked under a pseudo
+	// address made from the reply code with this bit set.
+	modeACAddrFlag = 0x1000000
+	// DF18 from a non-transponder device using an anonymous address, such as
+	// a ground vehicle or an obstacle
+	anonAddrFlag = 0x2000000
+	// TIS-B and ADS-R targets the ground station knows by a track number
+	nonICAOAddrFlag = 0x4000000
+)
+
+// How long a better source can go quiet before a worse one takes over
+const sourceTimeout = 60 * time.Second
+
+// setSource records where a message came from, keeping the best source heard
+// from recently
+func (aircraft *aircraftData) setSource(source modes.Source, now time.Time) {
+	if source >= aircraft.source || now.Sub(aircraft.sourceUpdated) > sourceTimeout {
+		aircraft.source = source
+		aircraft.sourceUpdated = now
+	}
+}
+
+// newAircraftData returns a record with every decoded value marked as unknown
+func newAircraftData(icaoAddr uint32, isMlat bool) aircraftData {
+	return aircraftData{
+		icaoAddr:  icaoAddr,
+		oRawLat:   math.MaxUint32,
+		oRawLon:   math.MaxUint32,
+		eRawLat:   math.MaxUint32,
+		eRawLon:   math.MaxUint32,
+		latitude:  math.MaxFloat64,
+		longitude: math.MaxFloat64,
+		distance:  math.MaxFloat64,
+		bearing:   math.MaxFloat64,
+		altitude:  math.MaxInt32,
+		callsign:  "",
+		mlat:      isMlat,
+		squawk:    math.MaxUint16,
+
+		groundSpeed:  math.MaxFloat64,
+		track:        math.MaxFloat64,
+		airspeed:     math.MaxInt32,
+		heading:      math.MaxFloat64,
+		vertRate:     math.MaxInt32,
+		gnssBaroDiff: math.MaxInt32,
+		integrity:    positionIntegrity{rc: math.MaxFloat64}}
+}
+
+// setPosition moves the aircraft and works out how far away it is, so readers
+// don't have to
+func (aircraft *aircraftData) setPosition(latitude float64, longitude float64) {
+	aircraft.latitude, aircraft.longitude = latitude, longitude
+	if latitude == math.MaxFloat64 || longitude == math.MaxFloat64 {
+		aircraft.distance, aircraft.bearing = math.MaxFloat64, math.MaxFloat64
+		return
+	}
+
+	aircraft.distance = GreatCircle(latitude, longitude, *baseLat, *baseLon)
+	aircraft.bearing = Bearing(*baseLat, *baseLon, latitude, longitude)
+}
+
+// snapshot copies the aircraft for a reader, leaving out the track history
+// whose ring would otherwise still be shared with the stored record
+func (aircraft *aircraftData) snapshot() aircraftData {
+	copied := *aircraft
+	copied.history = trackHistory{}
+	return copied
+}
 
-	mlat bool
+func (aircraft *aircraftData) addrString() string {
+	switch {
+	case aircraft.icaoAddr&modeACAddrFlag > 0:
+		return fmt.Sprintf("A%04x", aircraft.icaoAddr&0x7777)
+	case aircraft.icaoAddr&anonAddrFlag > 0:
+		return fmt.Sprintf("#%06x", aircraft.icaoAddr&0xFFFFFF)
+	case aircraft.icaoAddr&nonICAOAddrFlag > 0:
+		return fmt.Sprintf("~%06x", aircraft.icaoAddr&0xFFFFFF)
+	}
+	return fmt.Sprintf("%06x", aircraft.icaoAddr)
+}
+
+// Decoders that hand over already decoded aircraft, such as SBS and
+// aircraft.json feeds, write these fields as text
+
+// parseHexIdent reads an address as addrString writes ICAO and non-ICAO ones,
+// dump1090 marks the non-ICAO ones with a ~
+func parseHexIdent(hexIdent string) (uint32, bool) {
+	trimmed := strings.TrimPrefix(hexIdent, "~")
+	icaoAddr, err := strconv.ParseUint(trimmed, 16, 24)
+	if err != nil {
+		return 0, false
+	}
+	if trimmed != hexIdent {
+		return uint32(icaoAddr) | nonICAOAddrFlag, true
+	}
+	return uint32(icaoAddr), true
+}
+
+// parseSquawk reads four octal digits into the hex coded octal the decoder
+// gives squawks in
+func parseSquawk(squawk string) (uint16, bool) {
+	if len(squawk) != 4 || strings.Trim(squawk, "01234567") != "" {
+		return 0, false
+	}
+	code, err := strconv.ParseUint(squawk, 16, 16)
+	return uint16(code), err == nil
+}
+
+// padCallsign pads a callsign to 8 characters as a decoded identification is,
+// or returns "" if there isn't one
+func padCallsign(callsign string) string {
+	if callsign = strings.TrimSpace(callsign); callsign == "" {
+		return ""
+	}
+	return fmt.Sprintf("%-8s", callsign)
+}
+
+// direction is the ground track if we have one, otherwise the heading
+func (aircraft *aircraftData) direction() float64 {
+	if aircraft.track != math.MaxFloat64 {
+		return aircraft.track
+	}
+	return aircraft.heading
+}
+
+var emergencySquawks = map[uint16]string{
+	0x7500: "hijack",
+	0x7600: "radio failure",
+	0x7700: "general emergency",
+}
+
+// Emergency/priority states from extended squitter type 28
+var emergencyStates = []string{
+	"",
+	"general emergency",
+	"medical emergency",
+	"minimum fuel",
+	"no communications",
+	"unlawful interference",
+	"downed aircraft",
+	"",
+}
+
+// emergencyReason describes why an aircraft is declaring an emergency, or
+// returns an empty string if it isn't
+func (aircraft *aircraftData) emergencyReason() string {
+	if reason, ok := emergencySquawks[aircraft.squawk]; ok {
+		return fmt.Sprintf("squawking %04x (%s)", aircraft.squawk, reason)
+	}
+	if int(aircraft.emergency) < len(emergencyStates) && emergencyStates[aircraft.emergency] != "" {
+		return fmt.Sprintf("reporting %s", emergencyStates[aircraft.emergency])
+	}
+	return ""
+}
+
+// https://mode-s.org/decode/content/ads-b/2-identification.html
+var emitterCategories = map[uint8]string{
+	0xA1: "a light aircraft",
+	0xA2: "a small aircraft",
+	0xA3: "a large jet",
+	0xA4: "a high vortex large jet",
+	0xA5: "a heavy jet",
+	0xA6: "a high performance aircraft",
+	0xA7: "a helicopter",
+	0xB1: "a glider",
+	0xB2: "a balloon",
+	0xB3: "a parachutist",
+	0xB4: "an ultralight",
+	0xB6: "a drone",
+	0xB7: "a spacecraft",
+	0xC1: "an emergency vehicle",
+	0xC2: "a service vehicle",
+	0xC3: "an obstacle",
+	0xC4: "an obstacle",
+	0xC5: "an obstacle",
+}
+
+func (aircraft *aircraftData) categoryString() string {
+	if aircraft.category == 0 {
+		return "--"
+	}
+	return fmt.Sprintf("%02X", aircraft.category)
+}
+
+// describeCategory names the kind of aircraft, e.g. "a heavy jet", or returns
+// an empty string if it hasn't said or the category is reserved
+func (aircraft *aircraftData) describeCategory() string {
+	return emitterCategories[aircraft.category]
+}
+
+// categoryAllowed checks the aircraft against a comma separated list of
+// categories such as "A5,A7". "none" matches aircraft that haven't reported
+// one and an empty list matches everything.
+func (aircraft *aircraftData) categoryAllowed(categories string) bool {
+	if strings.TrimSpace(categories) == "" {
+		return true
+	}
+
+	for _, category := range strings.Split(categories, ",") {
+		category = strings.ToUpper(strings.TrimSpace(category))
+		if category == "NONE" && aircraft.category == 0 {
+			return true
+		}
+		if aircraft.category != 0 && category == aircraft.categoryString() {
+			return true
+		}
+	}
+	return false
+}
+
+func (aircraft *aircraftData) squawkString() string {
+	if aircraft.squawk == math.MaxUint16 {
+		return "----"
+	}
+	return fmt.Sprintf("%04x", aircraft.squawk)
 }
 
 type aircraftList []*aircraftData
 type aircraftMap map[uint32]*aircraftData
 
-type KnownAircraft struct {
+// The known aircraft are spread over this many locks
+const (
+	knownShardBits = 5
+	knownShards    = 1 << knownShardBits
+)
+
+type knownShard struct {
 	knownMap aircraftMap
 	mu       sync.Mutex
 }
 
+// KnownAircraft holds every aircraft we are tracking, spread over shards by
+// address so connections updating different aircraft don't wait on each
+// other. Records are updated in place under their shard's lock, readers only
+// ever get copies.
+type KnownAircraft struct {
+	shards [knownShards]knownShard
+}
+
+// shard picks the shard for an address. The multiply spreads out addresses
+// that only differ in their high bits, such as the flagged address spaces.
+func (kAircraft *KnownAircraft) shard(icaoAddr uint32) *knownShard {
+	return &kAircraft.shards[(icaoAddr*2654435761)>>(32-knownShardBits)]
+}
+
 func (kAircraft *KnownAircraft) getNumberOfKnown() (total int) {
-	kAircraft.mu.Lock()
-	defer kAircraft.mu.Unlock()
-	total = len(kAircraft.knownMap)
+	for i := range kAircraft.shards {
+		shard := &kAircraft.shards[i]
+		shard.mu.Lock()
+		total += len(shard.knownMap)
+		shard.mu.Unlock()
+	}
 	return total
 }
 
-func (kAircraft *KnownAircraft) getAircraft(icaoAddr uint32) (ptrAircraft *aircraftData, aircraftExists bool) {
-	kAircraft.mu.Lock()
-	defer kAircraft.mu.Unlock()
-	ptrAircraft, aircraftExists = kAircraft.knownMap[icaoAddr]
-	return ptrAircraft, aircraftExists
+// lastSquitter is when an aircraft last sent an all-call reply or extended
+// squitter. It is read without copying the aircraft, address/parity replies
+// look it up for every frame.
+func (kAircraft *KnownAircraft) lastSquitter(icaoAddr uint32) (lastSquitter time.Time, aircraftExists bool) {
+	shard := kAircraft.shard(icaoAddr)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+	if aircraft, known := shard.knownMap[icaoAddr]; known {
+		return aircraft.lastSquitter, true
+	}
+	return time.Time{}, false
 }
 
-func (kAircraft *KnownAircraft) addAircraft(icaoAddr uint32, aircraft *aircraftData) {
-	kAircraft.mu.Lock()
-	if kAircraft.knownMap == nil {
-		kAircraft.knownMap = make(aircraftMap)
+// Update applies update to an aircraft, adding it first if we haven't heard
+// from it before. Updates to the same aircraft happen one at a time and each
+// sees the result of the last, so none are lost. update must not hold on to
+// the record.
+func (kAircraft *KnownAircraft) Update(icaoAddr uint32, update func(aircraft *aircraftData)) {
+	shard := kAircraft.shard(icaoAddr)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	aircraft, aircraftExists := shard.knownMap[icaoAddr]
+	if !aircraftExists {
+		if shard.knownMap == nil {
+			shard.knownMap = make(aircraftMap)
+		}
+		created := newAircraftData(icaoAddr, false)
+		aircraft = &created
+		shard.knownMap[icaoAddr] = aircraft
 	}
 
-	kAircraft.knownMap[icaoAddr] = aircraft
-	kAircraft.mu.Unlock()
+	update(aircraft)
 }
 
-func (kAircraft *KnownAircraft) removeAircraft(icaoAddr uint32) {
-	kAircraft.mu.Lock()
-	delete(kAircraft.knownMap, icaoAddr)
-	kAircraft.mu.Unlock()
+// removeAircraftIf removes an aircraft if remove agrees, deciding on the
+// latest record so an update that lands after a snapshot was taken isn't
+// thrown away
+func (kAircraft *KnownAircraft) removeAircraftIf(icaoAddr uint32, remove func(aircraft *aircraftData) bool) {
+	shard := kAircraft.shard(icaoAddr)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+	if aircraft, aircraftExists := shard.knownMap[icaoAddr]; aircraftExists && remove(aircraft) {
+		delete(shard.knownMap, icaoAddr)
+	}
 }
 
 func (kAircraft *KnownAircraft) pruneKnown(now time.Time, timeout uint32) {
-	knownList := kAircraft.sortedAircraft()
-	for _, aircraft := range knownList {
-		if now.Sub(aircraft.lastPing).Seconds() > float64(timeout) {
-			kAircraft.removeAircraft(aircraft.icaoAddr)
+	for i := range kAircraft.shards {
+		shard := &kAircraft.shards[i]
+		shard.mu.Lock()
+		for icaoAddr, aircraft := range shard.knownMap {
+			if now.Sub(aircraft.lastPing).Seconds() > float64(timeout) {
+				delete(shard.knownMap, icaoAddr)
+			}
 		}
+		shard.mu.Unlock()
 	}
 }
 
+// sortedAircraft returns copies of every aircraft, nearest first. Each shard
+// is copied in one go so the result is consistent per aircraft, though not
+// across shards.
 func (kAircraft *KnownAircraft) sortedAircraft() (sortedAircraftList aircraftList) {
-	kAircraft.mu.Lock()
-	sortedAircraftList = make(aircraftList, 0, len(kAircraft.knownMap))
+	snapshots := make([]aircraftData, 0, kAircraft.getNumberOfKnown())
+	for i := range kAircraft.shards {
+		shard := &kAircraft.shards[i]
+		shard.mu.Lock()
+		for _, aircraft := range shard.knownMap {
+			snapshots = append(snapshots, aircraft.snapshot())
+		}
+		shard.mu.Unlock()
+	}
 
-	for _, aircraft := range kAircraft.knownMap {
-		sortedAircraftList = append(sortedAircraftList, aircraft)
+	sortedAircraftList = make(aircraftList, len(snapshots))
+	for i := range snapshots {
+		sortedAircraftList[i] = &snapshots[i]
 	}
 
 	sort.Sort(sortedAircraftList)
-	kAircraft.mu.Unlock()
 	return sortedAircraftList
 }
 
@@ -108,8 +434,7 @@ func (a aircraftList) Less(i, j int) bool {
 }
 
 func sortAircraftByDistance(a aircraftList, i, j int) bool {
-	return GreatCircle(a[i].latitude, a[i].longitude, *baseLat, *baseLon) <
-		GreatCircle(a[j].latitude, a[j].longitude, *baseLat, *baseLon)
+	return a[i].distance < a[j].distance
 }
 
 func sortAircraftByCallsign(a aircraftList, i, j int) bool {
@@ -120,5 +445,5 @@ func sortAircraftByCallsign(a aircraftList, i, j int) bool {
 	} else if a[i].callsign == "" && a[j].callsign != "" {
 		return false
 	}
-	return fmt.Sprintf("%06x", a[i].icaoAddr) < fmt.Sprintf("%06x", a[j].icaoAddr)
+	return a[i].icaoAddr < a[j].icaoAddr
 }
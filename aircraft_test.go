@@ -5,25 +5,39 @@ import (
 	"sync"
 	"testing"
 	"time"
+
+	"github.com/jsmithedin/overmyhouse/pkg/modes"
 )
 
 var testKnown *KnownAircraft
 
+// getAircraft returns a copy of an aircraft, for tests to check what was stored
+func (kAircraft *KnownAircraft) getAircraft(icaoAddr uint32) (ptrAircraft *aircraftData, aircraftExists bool) {
+	shard := kAircraft.shard(icaoAddr)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+	if aircraft, known := shard.knownMap[icaoAddr]; known {
+		snapshot := aircraft.snapshot()
+		return &snapshot, true
+	}
+	return nil, false
+}
+
+func noUpdate(aircraft *aircraftData) {}
+
 func TestAddingAircraftToKnown(t *testing.T) {
 	testKnown = &KnownAircraft{}
-	testAircraft := aircraftData{}
-	testKnown.addAircraft(123, &testAircraft)
-	if testKnown.knownMap[123] == nil {
+	testKnown.Update(123, noUpdate)
+	if sorted := testKnown.sortedAircraft(); len(sorted) != 1 || sorted[0].icaoAddr != 123 {
 		t.Errorf("Aircraft not added to known")
 	}
 }
 
 func TestGetNumberOfKnown(t *testing.T) {
 	testKnown = &KnownAircraft{}
-	testAircraft := aircraftData{}
 
 	for i := 0; i < 5; i++ {
-		testKnown.addAircraft(uint32(i), &testAircraft)
+		testKnown.Update(uint32(i), noUpdate)
 	}
 
 	total := testKnown.getNumberOfKnown()
@@ -33,40 +47,11 @@ func TestGetNumberOfKnown(t *testing.T) {
 	}
 }
 
-func TestRemoveAircraftFromKnown(t *testing.T) {
-	testKnown = &KnownAircraft{}
-	testAircraft := aircraftData{}
-	testKnown.addAircraft(123, &testAircraft)
-
-	testKnown.removeAircraft(123)
-
-	if testKnown.knownMap[123] != nil {
-		t.Errorf("Aircraft not removed from known")
-	}
-}
-
-func TestGetAircraftKnown(t *testing.T) {
-	testKnown = &KnownAircraft{}
-	testAircraft := aircraftData{}
-	testKnown.addAircraft(123, &testAircraft)
-
-	returnedAircraft, known := testKnown.getAircraft(123)
-	if returnedAircraft == nil || known == false {
-		t.Errorf("Couldn't get known aircraft")
-	}
-
-	returnedAircraft, known = testKnown.getAircraft(456)
-	if returnedAircraft != nil || known == true {
-		t.Errorf("Got an unknown aircraft")
-	}
-}
-
 func TestGetSortedAircraft(t *testing.T) {
 	testKnown = &KnownAircraft{}
-	testAircraft := aircraftData{}
 
 	for i := 0; i < 5; i++ {
-		testKnown.addAircraft(uint32(i), &testAircraft)
+		testKnown.Update(uint32(i), noUpdate)
 	}
 
 	sorted := testKnown.sortedAircraft()
@@ -78,12 +63,11 @@ func TestGetSortedAircraft(t *testing.T) {
 
 func TestSortWhilstAdding(t *testing.T) {
 	testKnown = &KnownAircraft{}
-	testAircraft := aircraftData{}
 
 	wg := sync.WaitGroup{}
 
 	wg.Add(1)
-	go addKnown(testKnown, 123, &testAircraft, &wg)
+	go addKnown(testKnown, 123, &wg)
 	wg.Add(1)
 	go sortKnown(testKnown, &wg)
 
@@ -92,8 +76,9 @@ func TestSortWhilstAdding(t *testing.T) {
 
 func TestPruneKnown(t *testing.T) {
 	testKnown = &KnownAircraft{}
-	testAircraft := aircraftData{lastPing: time.Now(), icaoAddr: 123}
-	testKnown.addAircraft(123, &testAircraft)
+	testKnown.Update(123, func(aircraft *aircraftData) {
+		aircraft.lastPing = time.Now()
+	})
 
 	now := time.Now().Add(time.Duration(61) * time.Second)
 
@@ -104,8 +89,112 @@ func TestPruneKnown(t *testing.T) {
 	}
 }
 
-func addKnown(ka *KnownAircraft, icao uint32, ac *aircraftData, wg *sync.WaitGroup) {
-	ka.addAircraft(icao, ac)
+func TestUpdateKnown(t *testing.T) {
+	testKnown = &KnownAircraft{}
+
+	testKnown.Update(123, func(aircraft *aircraftData) {
+		aircraft.callsign = "ABC123"
+	})
+	first, known := testKnown.getAircraft(123)
+	if !known || first.callsign != "ABC123" || first.altitude != math.MaxInt32 {
+		t.Fatalf("expected a new aircraft called ABC123, got: %+v", first)
+	}
+
+	testKnown.Update(123, func(aircraft *aircraftData) {
+		aircraft.altitude = 38000
+	})
+	second, _ := testKnown.getAircraft(123)
+	if second.callsign != "ABC123" || second.altitude != 38000 {
+		t.Fatalf("expected ABC123 at 38000, got: %v at %v", second.callsign, second.altitude)
+	}
+	if first.altitude != math.MaxInt32 {
+		t.Fatalf("expected the earlier snapshot to be left alone, got: %v", first.altitude)
+	}
+}
+
+func TestRemoveAircraftIf(t *testing.T) {
+	testKnown = &KnownAircraft{}
+	testKnown.Update(123, func(aircraft *aircraftData) {
+		aircraft.altitude = 1000
+	})
+
+	testKnown.removeAircraftIf(123, func(aircraft *aircraftData) bool { return aircraft.altitude > 1000 })
+	if _, known := testKnown.getAircraft(123); !known {
+		t.Fatalf("expected the aircraft to be kept")
+	}
+
+	testKnown.removeAircraftIf(123, func(aircraft *aircraftData) bool { return aircraft.altitude == 1000 })
+	if _, known := testKnown.getAircraft(123); known {
+		t.Fatalf("expected the aircraft to be removed")
+	}
+}
+
+// Run with -race: writers, readers and pruning all at once
+func TestUpdateConcurrently(t *testing.T) {
+	const writers, updates = 8, 500
+	testKnown = &KnownAircraft{}
+
+	wg := sync.WaitGroup{}
+	done := make(chan struct{})
+
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < updates; j++ {
+				testKnown.Update(123, func(aircraft *aircraftData) {
+					aircraft.posRejects++
+					aircraft.lastPing = time.Now()
+				})
+			}
+		}()
+	}
+
+	readers := sync.WaitGroup{}
+	readers.Add(1)
+	go func() {
+		defer readers.Done()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			for _, aircraft := range testKnown.sortedAircraft() {
+				_ = aircraft.posRejects
+				_ = aircraft.lastPing
+			}
+			testKnown.pruneKnown(time.Now(), 60)
+		}
+	}()
+
+	wg.Wait()
+	close(done)
+	readers.Wait()
+
+	aircraft, known := testKnown.getAircraft(123)
+	if !known || aircraft.posRejects != writers*updates {
+		t.Fatalf("expected: %v updates, got: %+v", writers*updates, aircraft)
+	}
+}
+
+func BenchmarkSortedAircraft(b *testing.B) {
+	testKnown = &KnownAircraft{}
+	for i := 0; i < 1000; i++ {
+		testKnown.Update(uint32(i), func(aircraft *aircraftData) {
+			aircraft.setPosition(*baseLat+float64(i%100)/100, *baseLon+float64(i/100)/100)
+		})
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_ = testKnown.sortedAircraft()
+	}
+}
+
+func addKnown(ka *KnownAircraft, icao uint32, wg *sync.WaitGroup) {
+	ka.Update(icao, noUpdate)
 	wg.Done()
 }
 
@@ -171,8 +260,10 @@ func TestLessOneLocation(t *testing.T) {
 
 func TestLessTwoLocations(t *testing.T) {
 	testList := setupAircraftList()
-	testAircraft1 := aircraftData{icaoAddr: 2, callsign: "c", latitude: 1.0, longitude: 1.0}
-	testAircraft2 := aircraftData{icaoAddr: 2, callsign: "d", latitude: 2.0, longitude: 2.0}
+	testAircraft1 := aircraftData{icaoAddr: 2, callsign: "c"}
+	testAircraft1.setPosition(1.0, 1.0)
+	testAircraft2 := aircraftData{icaoAddr: 2, callsign: "d"}
+	testAircraft2.setPosition(2.0, 2.0)
 	testList = append(testList, &testAircraft1)
 	testList = append(testList, &testAircraft2)
 
@@ -181,6 +272,29 @@ func TestLessTwoLocations(t *testing.T) {
 	if less != false {
 		t.Errorf("Didn't properly less")
 	}
+
+	less = testList.Less(1, 0)
+
+	if less != true {
+		t.Errorf("Didn't properly less")
+	}
+}
+
+func TestSetPosition(t *testing.T) {
+	testAircraft := newAircraftData(123, false)
+	testAircraft.setPosition(*baseLat+1, *baseLon)
+
+	if math.Abs(testAircraft.distance-111195) > 100 {
+		t.Errorf("Expected about 111195 m got %v", testAircraft.distance)
+	}
+	if math.Abs(testAircraft.bearing) > 0.01 {
+		t.Errorf("Expected due north got %v", testAircraft.bearing)
+	}
+
+	testAircraft.setPosition(math.MaxFloat64, math.MaxFloat64)
+	if testAircraft.distance != math.MaxFloat64 || testAircraft.bearing != math.MaxFloat64 {
+		t.Errorf("Expected no distance or bearing got %v %v", testAircraft.distance, testAircraft.bearing)
+	}
 }
 
 func TestLessByCallsign(t *testing.T) {
@@ -218,3 +332,152 @@ func TestLessByCallsign(t *testing.T) {
 		t.Errorf("Didn't properly less")
 	}
 }
+
+func TestEmergencyReason(t *testing.T) {
+	tests := []struct {
+		squawk    uint16
+		emergency uint8
+		want      string
+	}{
+		{squawk: 0x7500, want: "squawking 7500 (hijack)"},
+		{squawk: 0x7600, want: "squawking 7600 (radio failure)"},
+		{squawk: 0x7700, emergency: 1, want: "squawking 7700 (general emergency)"},
+		{squawk: 0x1234, emergency: 3, want: "reporting minimum fuel"},
+		{squawk: 0x1234, emergency: 7, want: ""},
+		{squawk: math.MaxUint16, want: ""},
+	}
+
+	for _, tc := range tests {
+		aircraft := aircraftData{squawk: tc.squawk, emergency: tc.emergency}
+		if got := aircraft.emergencyReason(); got != tc.want {
+			t.Errorf("Expected %q got %q", tc.want, got)
+		}
+	}
+}
+
+func TestCategoryAllowed(t *testing.T) {
+	tests := []struct {
+		category   uint8
+		categories string
+		want       bool
+	}{
+		{category: 0xA5, categories: "", want: true},
+		{category: 0, categories: "", want: true},
+		{category: 0xA5, categories: "A5,A7", want: true},
+		{category: 0xA7, categories: " a5, a7 ", want: true},
+		{category: 0xA1, categories: "A5,A7", want: false},
+		{category: 0, categories: "A5,A7", want: false},
+		{category: 0, categories: "A5,none", want: true},
+	}
+
+	for _, tc := range tests {
+		aircraft := aircraftData{category: tc.category}
+		if got := aircraft.categoryAllowed(tc.categories); got != tc.want {
+			t.Errorf("%02X in %q: expected %v got %v", tc.category, tc.categories, tc.want, got)
+		}
+	}
+}
+
+func TestDescribeCategory(t *testing.T) {
+	tests := []struct {
+		category uint8
+		want     string
+		code     string
+	}{
+		{category: 0xA5, want: "a heavy jet", code: "A5"},
+		{category: 0xA7, want: "a helicopter", code: "A7"},
+		{category: 0xB5, want: "", code: "B5"},
+		{category: 0, want: "", code: "--"},
+	}
+
+	for _, tc := range tests {
+		aircraft := aircraftData{category: tc.category}
+		if got := aircraft.describeCategory(); got != tc.want {
+			t.Errorf("Expected %q got %q", tc.want, got)
+		}
+		if got := aircraft.categoryString(); got != tc.code {
+			t.Errorf("Expected %q got %q", tc.code, got)
+		}
+	}
+}
+
+func TestSetSource(t *testing.T) {
+	now := time.Now()
+	aircraft := newAircraftData(0x40621D, false)
+
+	tests := []struct {
+		source modes.Source
+		at     time.Time
+		want   modes.Source
+	}{
+		{source: modes.SourceModeS, at: now, want: modes.SourceModeS},
+		{source: modes.SourceADSB, at: now, want: modes.SourceADSB},
+		{source: modes.SourceModeS, at: now.Add(time.Second), want: modes.SourceADSB},
+		{source: modes.SourceMLAT, at: now.Add(sourceTimeout + time.Second), want: modes.SourceMLAT},
+	}
+
+	for _, tc := range tests {
+		aircraft.setSource(tc.source, tc.at)
+		if aircraft.source != tc.want {
+			t.Errorf("Expected %v got %v", tc.want, aircraft.source)
+		}
+	}
+}
+
+func TestLastSquitter(t *testing.T) {
+	testKnown = &KnownAircraft{}
+	now := time.Now()
+	testKnown.Update(123, func(aircraft *aircraftData) {
+		aircraft.lastSquitter = now
+	})
+
+	if lastSquitter, known := testKnown.lastSquitter(123); !known || !lastSquitter.Equal(now) {
+		t.Fatalf("expected: %v, got: %v %v", now, lastSquitter, known)
+	}
+	if _, known := testKnown.lastSquitter(456); known {
+		t.Fatalf("expected 456 to be unknown")
+	}
+}
+
+func Test_parseDecodedFields(t *testing.T) {
+	addrs := []struct {
+		hexIdent string
+		icaoAddr uint32
+		ok       bool
+	}{
+		{hexIdent: "4840D6", icaoAddr: 0x4840D6, ok: true},
+		{hexIdent: "~4840d6", icaoAddr: 0x4840D6 | nonICAOAddrFlag, ok: true},
+		{hexIdent: "48Z0D6"},
+		{hexIdent: "14840D6"},
+		{hexIdent: ""},
+	}
+	for _, tc := range addrs {
+		if icaoAddr, ok := parseHexIdent(tc.hexIdent); icaoAddr != tc.icaoAddr || ok != tc.ok {
+			t.Fatalf("expected: %x %v, got: %x %v", tc.icaoAddr, tc.ok, icaoAddr, ok)
+		}
+	}
+
+	squawks := []struct {
+		squawk string
+		code   uint16
+		ok     bool
+	}{
+		{squawk: "7700", code: 0x7700, ok: true},
+		{squawk: "0040", code: 0x0040, ok: true},
+		{squawk: "7800"},
+		{squawk: "770"},
+		{squawk: "77000"},
+	}
+	for _, tc := range squawks {
+		if code, ok := parseSquawk(tc.squawk); code != tc.code || ok != tc.ok {
+			t.Fatalf("expected: %x %v, got: %x %v", tc.code, tc.ok, code, ok)
+		}
+	}
+
+	callsigns := map[string]string{"KLM1023": "KLM1023 ", " MDI08  ": "MDI08   ", "EZY12ABC": "EZY12ABC", "  ": ""}
+	for callsign, want := range callsigns {
+		if got := padCallsign(callsign); got != want {
+			t.Fatalf("expected: %q, got: %q", want, got)
+		}
+	}
+}
@@ -0,0 +1,315 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jsmithedin/overmyhouse/pkg/modes"
+)
+
+// aircraft.json is the aircraft list dump1090-fa, readsb and tar1090 serve
+// over HTTP, already decoded. Only the fields we track are read, and where
+// older dump1090 versions named a field differently both are read.
+type aircraftJSON struct {
+	Now      float64        `json:"now"`
+	Aircraft []jsonAircraft `json:"aircraft"`
+}
+
+type jsonAircraft struct {
+	Hex      string `json:"hex"`
+	Type     string `json:"type"`
+	Flight   string `json:"flight"`
+	Category string `json:"category"`
+	Squawk   string `json:"squawk"`
+	// A number of feet or "ground"
+	AltBaro  json.RawMessage `json:"alt_baro"`
+	Altitude json.RawMessage `json:"altitude"`
+
+	GroundSpeed *float64 `json:"gs"`
+	Speed       *float64 `json:"speed"`
+	Track       *float64 `json:"track"`
+	BaroRate    *float64 `json:"baro_rate"`
+	VertRate    *float64 `json:"vert_rate"`
+
+	Lat *float64 `json:"lat"`
+	Lon *float64 `json:"lon"`
+	// Fields that came from MLAT rather than the aircraft itself
+	MLAT []string `json:"mlat"`
+
+	// Seconds since anything was heard from the aircraft, and since its position
+	Seen    float64  `json:"seen"`
+	SeenPos *float64 `json:"seen_pos"`
+}
+
+const (
+	// Big enough for thousands of aircraft, anything bigger isn't an aircraft list
+	maxAircraftJSON = 16 << 20
+	// Long enough for a decoder on the far side of a slow link
+	jsonPollTimeout = 10 * time.Second
+)
+
+// Where readsb's type field says the data came from
+var jsonAircraftSources = map[string]modes.Source{
+	"adsb_icao":      modes.SourceADSB,
+	"adsb_icao_nt":   modes.SourceADSB,
+	"adsb_other":     modes.SourceADSB,
+	"adsr_icao":      modes.SourceADSR,
+	"adsr_other":     modes.SourceADSR,
+	"tisb_icao":      modes.SourceTISB,
+	"tisb_other":     modes.SourceTISB,
+	"tisb_trackfile": modes.SourceTISB,
+	"mlat":           modes.SourceMLAT,
+	"mode_s":         modes.SourceModeS,
+}
+
+// altitude is the barometric altitude and whether the aircraft is on the
+// ground, ok is false if neither is known
+func (entry *jsonAircraft) altitude() (altitude int32, onGround bool, ok bool) {
+	raw := entry.AltBaro
+	if len(raw) == 0 {
+		raw = entry.Altitude
+	}
+
+	var value interface{}
+	if err := json.Unmarshal(raw, &value); err != nil {
+		return 0, false, false
+	}
+	switch value := value.(type) {
+	case float64:
+		return int32(math.Round(value)), false, true
+	case string:
+		return 0, value == "ground", value == "ground"
+	}
+	return 0, false, false
+}
+
+// trackJSONAircraft updates an aircraft from its entry, returning how far away
+// it is if the entry moved it, otherwise math.MaxFloat64. The entry's ages are
+// taken from now, and its position is only used if newPosition says it hasn't
+// been seen before.
+func trackJSONAircraft(icaoAddr uint32, entry jsonAircraft, newPosition bool, now time.Time,
+	knownAircraft *KnownAircraft) (distance float64) {
+	distance = math.MaxFloat64
+	heard := now.Add(-time.Duration(entry.Seen * float64(time.Second)))
+
+	knownAircraft.Update(icaoAddr, func(aircraft *aircraftData) {
+		if heard.After(aircraft.lastPing) {
+			aircraft.lastPing = heard
+		}
+
+		mlat := false
+		for _, field := range entry.MLAT {
+			if field == "lat" || field == "lon" {
+				mlat = true
+			}
+		}
+		aircraft.mlat = mlat
+		source, known := jsonAircraftSources[entry.Type]
+		if !known {
+			source = modes.SourceUnknown
+		}
+		aircraft.setSource(source, heard)
+		if source == modes.SourceADSB || source == modes.SourceADSR || source == modes.SourceTISB {
+			aircraft.lastSquitter = heard
+		}
+
+		if callsign := padCallsign(entry.Flight); callsign != "" {
+			aircraft.callsign = callsign
+		}
+		if category, err := strconv.ParseUint(entry.Category, 16, 8); err == nil {
+			aircraft.category = uint8(category)
+		}
+		if squawk, ok := parseSquawk(entry.Squawk); ok {
+			aircraft.squawk = squawk
+		}
+		if altitude, onGround, ok := entry.altitude(); ok {
+			aircraft.onGround = onGround
+			if !onGround {
+				aircraft.altitude = altitude
+			}
+		}
+		if speed := firstOf(entry.GroundSpeed, entry.Speed); speed != nil {
+			aircraft.groundSpeed = *speed
+		}
+		if entry.Track != nil {
+			aircraft.track = *entry.Track
+		}
+		if vertRate := firstOf(entry.BaroRate, entry.VertRate); vertRate != nil {
+			aircraft.vertRate = int32(math.Round(*vertRate))
+			aircraft.vertRateGNSS = false
+		}
+
+		if !newPosition || entry.Lat == nil || entry.Lon == nil || math.Abs(*entry.Lat) > 90 ||
+			math.Abs(*entry.Lon) > 180 {
+			return
+		}
+		posTime := heard
+		if entry.SeenPos != nil {
+			posTime = now.Add(-time.Duration(*entry.SeenPos * float64(time.Second)))
+		}
+		if acceptPosition(aircraft, *entry.Lat, *entry.Lon, posTime) {
+			distance = aircraft.distance
+		}
+	})
+	return distance
+}
+
+// firstOf returns the first value given, for fields dump1090 has renamed
+func firstOf(values ...*float64) *float64 {
+	for _, value := range values {
+		if value != nil {
+			return value
+		}
+	}
+	return nil
+}
+
+// jsonPoller polls the aircraft.json of an ordered list of decoders, sticking
+// with the first that answers, and feeds what they've heard into the known
+// aircraft. It stands in for a feedClient for sources that don't offer a raw
+// port.
+type jsonPoller struct {
+	source   *feedSource
+	urls     []string
+	interval time.Duration
+	client   *http.Client
+
+	// URL the last poll came from, empty if it failed
+	current string
+	// Whether every URL failed last time, so it is only logged once
+	failing bool
+	// When the decoder last heard each aircraft in the last list
+	heard map[uint32]jsonHeard
+}
+
+// jsonHeard is when a decoder last heard from an aircraft and last had a
+// position for it, in seconds on its clock
+type jsonHeard struct {
+	message  float64
+	position float64
+}
+
+// newJSONPoller makes a poller for a comma separated list of aircraft.json
+// URLs, the primary first
+func newJSONPoller(source *feedSource, interval time.Duration) *jsonPoller {
+	if interval <= 0 {
+		interval = time.Second
+	}
+	poller := &jsonPoller{
+		source:   source,
+		interval: interval,
+		client:   &http.Client{Timeout: jsonPollTimeout},
+	}
+	for _, url := range strings.Split(source.feeders, ",") {
+		if url = strings.TrimSpace(url); url != "" {
+			poller.urls = append(poller.urls, url)
+		}
+	}
+	return poller
+}
+
+// run polls every interval until quit is closed
+func (poller *jsonPoller) run(knownAircraft *KnownAircraft, quit <-chan struct{}) {
+	ticker := time.NewTicker(poller.interval)
+	defer ticker.Stop()
+
+	for {
+		poller.poll(knownAircraft)
+
+		select {
+		case <-ticker.C:
+		case <-quit:
+			return
+		}
+	}
+}
+
+// poll fetches the aircraft list from the first URL that gives one and tracks
+// it, logging when that changes to a different URL or none at all
+func (poller *jsonPoller) poll(knownAircraft *KnownAircraft) {
+	var failures []string
+	for _, url := range poller.urls {
+		list, err := poller.fetch(url)
+		if err != nil {
+			failures = append(failures, fmt.Sprintf("%s: %v", url, err))
+			continue
+		}
+
+		if url != poller.current {
+			log.Printf("Polling %s for %s\n", url, poller.source.name)
+			// A different decoder has a different clock
+			poller.current, poller.heard = url, nil
+		}
+		poller.failing = false
+		poller.track(list, time.Now(), knownAircraft)
+		return
+	}
+
+	if !poller.failing {
+		log.Printf("Couldn't poll any aircraft.json for %s: %s\n", poller.source.name, strings.Join(failures, ", "))
+	}
+	poller.failing, poller.current = true, ""
+}
+
+func (poller *jsonPoller) fetch(url string) (aircraftJSON, error) {
+	var list aircraftJSON
+
+	resp, err := poller.client.Get(url)
+	if err != nil {
+		return list, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return list, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxAircraftJSON)).Decode(&list); err != nil {
+		return list, err
+	}
+	return list, nil
+}
+
+// track feeds one aircraft list into the known aircraft, each aircraft with
+// something new counting as a frame from the source. An aircraft the decoder
+// still lists but hasn't heard since the last poll is left alone, so it still
+// times out here, as are ones it last heard too long ago to keep.
+func (poller *jsonPoller) track(list aircraftJSON, now time.Time, knownAircraft *KnownAircraft) {
+	// Times are compared on the decoder's clock, ours isn't the same and
+	// moves on between polls
+	listTime := list.Now
+	if listTime == 0 {
+		listTime = float64(now.UnixNano()) / float64(time.Second)
+	}
+
+	heard := make(map[uint32]jsonHeard, len(list.Aircraft))
+	for _, entry := range list.Aircraft {
+		icaoAddr, ok := parseHexIdent(entry.Hex)
+		if !ok || entry.Seen > float64(*cleanupTime) {
+			continue
+		}
+
+		latest := jsonHeard{message: listTime - entry.Seen, position: math.Inf(-1)}
+		if entry.SeenPos != nil {
+			latest.position = listTime - *entry.SeenPos
+		}
+		previous, seen := poller.heard[icaoAddr]
+		heard[icaoAddr] = latest
+		if seen && latest.message <= previous.message {
+			continue
+		}
+
+		newPosition := !seen || latest.position > previous.position
+		poller.source.countFrame()
+		if distance := trackJSONAircraft(icaoAddr, entry, newPosition, now, knownAircraft); distance != math.MaxFloat64 {
+			poller.source.countPosition(distance)
+		}
+	}
+	poller.heard = heard
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func Test_trackJSONAircraft(t *testing.T) {
+	tests := []struct {
+		name      string
+		entry     string
+		icaoAddr  uint32
+		callsign  string
+		altitude  int32
+		onGround  bool
+		squawk    uint16
+		vertRate  int32
+		category  uint8
+		mlat      bool
+		positions int
+	}{
+		{
+			name: "readsb",
+			entry: `{"hex":"4840d6","type":"adsb_icao","flight":"KLM1023 ","alt_baro":38000,"gs":420.5,"track":91.2,
+				"baro_rate":-640,"squawk":"7700","category":"A3","lat":55.95,"lon":-3.3,"seen_pos":0.4,"seen":0.1}`,
+			icaoAddr: 0x4840D6, callsign: "KLM1023 ", altitude: 38000, squawk: 0x7700, vertRate: -640, category: 0xA3,
+			positions: 1,
+		},
+		{
+			name: "older dump1090 with an MLAT position",
+			entry: `{"hex":"4ca4e5","flight":"RYR1427","altitude":3500,"speed":180,"vert_rate":1024,"lat":55.92,
+				"lon":-3.25,"mlat":["lat","lon","track","speed"],"seen_pos":2.1,"seen":1.5}`,
+			icaoAddr: 0x4CA4E5, callsign: "RYR1427 ", altitude: 3500, squawk: math.MaxUint16, vertRate: 1024, mlat: true,
+			positions: 1,
+		},
+		{
+			name:     "on the ground from a non ICAO address",
+			entry:    `{"hex":"~2a0f1c","type":"tisb_other","alt_baro":"ground","squawk":"1200","seen":3}`,
+			icaoAddr: 0x2A0F1C | nonICAOAddrFlag, altitude: math.MaxInt32, onGround: true, squawk: 0x1200,
+			vertRate: math.MaxInt32,
+		},
+		{
+			name:     "out of range",
+			entry:    `{"hex":"3c6444","alt_baro":12000,"lat":48.35,"lon":11.78,"seen_pos":1,"seen":1}`,
+			icaoAddr: 0x3C6444, altitude: 12000, squawk: math.MaxUint16, vertRate: math.MaxInt32,
+		},
+	}
+
+	for _, tc := range tests {
+		testKnownAircraft := &KnownAircraft{}
+		source := &feedSource{name: "tar1090", format: formatJSON}
+		poller := newJSONPoller(source, time.Second)
+
+		var list aircraftJSON
+		if err := json.Unmarshal([]byte(`{"now":1700000000.5,"aircraft":[`+tc.entry+`]}`), &list); err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		now := time.Now()
+		poller.track(list, now, testKnownAircraft)
+
+		aircraft, known := testKnownAircraft.getAircraft(tc.icaoAddr)
+		if !known {
+			t.Fatalf("%s: expected %06X to be known", tc.name, tc.icaoAddr)
+		}
+		if aircraft.callsign != tc.callsign || aircraft.altitude != tc.altitude || aircraft.onGround != tc.onGround ||
+			aircraft.squawk != tc.squawk || aircraft.vertRate != tc.vertRate || aircraft.category != tc.category ||
+			aircraft.mlat != tc.mlat {
+			t.Fatalf("%s: expected: %+v, got: %+v", tc.name, tc, aircraft)
+		}
+		if len(aircraft.history.points) != 0 {
+			t.Fatalf("%s: expected the snapshot to leave out the history", tc.name)
+		}
+		if trail := testKnownAircraft.Trail(tc.icaoAddr, time.Time{}); len(trail) != tc.positions {
+			t.Fatalf("%s: expected: %v, got: %+v", tc.name, tc.positions, trail)
+		}
+		if source.frames != 1 || int(source.positions) != tc.positions {
+			t.Fatalf("%s: expected 1 frame and %v positions, got: %v %v", tc.name, tc.positions, source.frames,
+				source.positions)
+		}
+	}
+}
+
+func Test_trackJSONAircraftBehindRaw(t *testing.T) {
+	testKnownAircraft := &KnownAircraft{}
+	now := time.Now()
+	testKnownAircraft.Update(0x4840D6, func(aircraft *aircraftData) {
+		acceptPosition(aircraft, 55.95, -3.3, now)
+	})
+
+	// The decoder's fix is older than the one from the raw feed
+	lat, lon, seenPos := 55.95, -3.3, 3.0
+	entry := jsonAircraft{Hex: "4840d6", Lat: &lat, Lon: &lon, SeenPos: &seenPos}
+	for i := 0; i < maxPositionRejects+1; i++ {
+		if distance := trackJSONAircraft(0x4840D6, entry, true, now, testKnownAircraft); distance != math.MaxFloat64 {
+			t.Fatalf("expected the older fix to be ignored, got: %v", distance)
+		}
+	}
+
+	aircraft, _ := testKnownAircraft.getAircraft(0x4840D6)
+	if aircraft.latitude != 55.95 || aircraft.posRejects != 0 || !aircraft.lastPos.Equal(now) {
+		t.Fatalf("expected the raw fix to be kept, got: %v %v %v", aircraft.latitude, aircraft.posRejects,
+			aircraft.lastPos)
+	}
+	if trail := testKnownAircraft.Trail(0x4840D6, time.Time{}); len(trail) != 1 || !trail[0].Time.Equal(now) {
+		t.Fatalf("expected just the raw fix, got: %+v", trail)
+	}
+}
+
+func Test_jsonPollerSkipsOldNews(t *testing.T) {
+	testKnownAircraft := &KnownAircraft{}
+	source := &feedSource{name: "tar1090", format: formatJSON}
+	poller := newJSONPoller(source, time.Second)
+	now := time.Now()
+
+	lists := []struct {
+		json      string
+		frames    uint64
+		positions uint64
+	}{
+		{json: `{"now":100,"aircraft":[{"hex":"4840d6","lat":55.95,"lon":-3.3,"seen_pos":1,"seen":0.5},
+			{"hex":"4ca4e5","seen":300},{"hex":"zzzzzz","seen":0}]}`, frames: 1, positions: 1},
+		// Nothing new on the decoder's clock, even though ours has moved on
+		{json: `{"now":101,"aircraft":[{"hex":"4840d6","lat":55.95,"lon":-3.3,"seen_pos":2,"seen":1.5}]}`,
+			frames: 1, positions: 1},
+		// A new message but the same position
+		{json: `{"now":102,"aircraft":[{"hex":"4840d6","lat":55.95,"lon":-3.3,"seen_pos":3,"seen":0.1}]}`,
+			frames: 2, positions: 1},
+		{json: `{"now":103,"aircraft":[{"hex":"4840d6","lat":55.951,"lon":-3.3,"seen_pos":0.2,"seen":0.2}]}`,
+			frames: 3, positions: 2},
+	}
+
+	for i, l := range lists {
+		var list aircraftJSON
+		if err := json.Unmarshal([]byte(l.json), &list); err != nil {
+			t.Fatal(err)
+		}
+		poller.track(list, now.Add(time.Duration(i)*time.Second), testKnownAircraft)
+		if source.frames != l.frames || source.positions != l.positions {
+			t.Fatalf("list %d: expected: %v %v, got: %v %v", i, l.frames, l.positions, source.frames, source.positions)
+		}
+	}
+
+	if _, known := testKnownAircraft.getAircraft(0x4CA4E5); known {
+		t.Fatalf("expected an aircraft last heard 5 minutes ago to be left out")
+	}
+}
+
+func Test_jsonPollerFailover(t *testing.T) {
+	var primaryUp int32
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&primaryUp) == 0 {
+			http.Error(w, "starting", http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprint(w, `{"now":200,"aircraft":[{"hex":"4ca4e5","flight":"RYR1427","seen":0.1}]}`)
+	}))
+	defer primary.Close()
+	backup := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"now":100,"aircraft":[{"hex":"4840d6","flight":"KLM1023","seen":0.1}]}`)
+	}))
+	defer backup.Close()
+
+	testKnownAircraft := &KnownAircraft{}
+	source := &feedSource{name: "tar1090", feeders: primary.URL + ", " + backup.URL, format: formatJSON}
+	poller := newJSONPoller(source, time.Second)
+
+	poller.poll(testKnownAircraft)
+	if aircraft, known := testKnownAircraft.getAircraft(0x4840D6); !known || aircraft.callsign != "KLM1023 " {
+		t.Fatalf("expected KLM1023 from the backup")
+	}
+	if poller.current != backup.URL {
+		t.Fatalf("expected: %v, got: %v", backup.URL, poller.current)
+	}
+
+	atomic.StoreInt32(&primaryUp, 1)
+	poller.poll(testKnownAircraft)
+	if aircraft, known := testKnownAircraft.getAircraft(0x4CA4E5); !known || aircraft.callsign != "RYR1427 " {
+		t.Fatalf("expected RYR1427 from the primary")
+	}
+	if poller.current != primary.URL {
+		t.Fatalf("expected: %v, got: %v", primary.URL, poller.current)
+	}
+
+	primary.Close()
+	backup.Close()
+	poller.poll(testKnownAircraft)
+	if poller.current != "" || !poller.failing {
+		t.Fatalf("expected every poll to fail, got: %v", poller.current)
+	}
+	if source.frames != 2 {
+		t.Fatalf("expected: 2, got: %v", source.frames)
+	}
+}
+
+func Test_jsonPollerRun(t *testing.T) {
+	var polls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&polls, 1)
+		fmt.Fprint(w, `{"aircraft":[{"hex":"4840d6","seen":0}]}`)
+	}))
+	defer server.Close()
+
+	testKnownAircraft := &KnownAircraft{}
+	poller := newJSONPoller(&feedSource{name: "tar1090", feeders: server.URL, format: formatJSON}, 10*time.Millisecond)
+
+	quit := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		poller.run(testKnownAircraft, quit)
+		close(done)
+	}()
+	time.Sleep(100 * time.Millisecond)
+	close(quit)
+	<-done
+
+	if atomic.LoadInt32(&polls) < 2 {
+		t.Fatalf("expected repeated polls, got: %v", polls)
+	}
+	if _, known := testKnownAircraft.getAircraft(0x4840D6); !known {
+		t.Fatalf("expected 4840D6 to be known")
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"io"
+)
+
+// AVR is dump1090's text output, one frame per line as hex between a start
+// character and a semicolon:
+//
+//	*8D4840D6202CC371C32CE0576098;      raw
+//	@FF004D4C41548D4840D6202CC371C32CE0576098;      with a 6 byte timestamp
+//	<FF004D4C4154288D4840D6202CC371C32CE0576098;    with a timestamp and signal level
+const (
+	avrRaw       = '*'
+	avrTimestamp = '@'
+	avrSignal    = '<'
+	avrEnd       = ';'
+)
+
+// Long enough for any frame, anything longer is garbage
+const avrMaxLine = 128
+
+// avrReader splits an AVR text stream into the same frames a beastReader
+// gives, so the rest of the pipeline doesn't care which it came from.
+type avrReader struct {
+	r *bufio.Reader
+
+	stats beastStats
+}
+
+func newAVRReader(r io.Reader) *avrReader {
+	return &avrReader{r: bufio.NewReaderSize(r, avrMaxLine)}
+}
+
+// readFrame returns the next well formed frame in the stream. Lines that
+// aren't frames are skipped and counted rather than returned.
+func (a *avrReader) readFrame() (beastFrame, error) {
+	for {
+		line, err := readLine(a.r, &a.stats)
+		if err != nil {
+			return beastFrame{}, err
+		}
+
+		frame, ok := parseAVRLine(line)
+		if !ok {
+			a.stats.malformed++
+			continue
+		}
+
+		a.stats.frames++
+		return frame, nil
+	}
+}
+
+// readLine returns the next line of a text stream that isn't blank, without
+// surrounding space. Lines too long for the reader's buffer are thrown away
+// and counted as malformed. A last line without a newline still counts.
+func readLine(r *bufio.Reader, stats *beastStats) ([]byte, error) {
+	for {
+		line, err := r.ReadSlice('\n')
+		if err == bufio.ErrBufferFull {
+			stats.malformed++
+			for err == bufio.ErrBufferFull {
+				_, err = r.ReadSlice('\n')
+			}
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if err != nil && (err != io.EOF || len(bytes.TrimSpace(line)) == 0) {
+			return nil, err
+		}
+
+		if line = bytes.TrimSpace(line); len(line) > 0 {
+			return line, nil
+		}
+	}
+}
+
+func (a *avrReader) frameStats() beastStats {
+	return a.stats
+}
+
+// parseAVRLine decodes one line, without its line ending
+func parseAVRLine(line []byte) (beastFrame, bool) {
+	if len(line) < 2 || line[len(line)-1] != avrEnd {
+		return beastFrame{}, false
+	}
+
+	data := make([]byte, hex.DecodedLen(len(line)-2))
+	if _, err := hex.Decode(data, line[1:len(line)-1]); err != nil {
+		return beastFrame{}, false
+	}
+
+	frame := beastFrame{timestamp: make([]byte, 6)}
+	switch line[0] {
+	case avrRaw:
+		frame.payload = data
+	case avrTimestamp:
+		if len(data) < 6 {
+			return beastFrame{}, false
+		}
+		frame.timestamp, frame.payload = data[:6], data[6:]
+	case avrSignal:
+		if len(data) < 7 {
+			return beastFrame{}, false
+		}
+		frame.timestamp, frame.signal, frame.payload = data[:6], data[6], data[7:]
+	default:
+		return beastFrame{}, false
+	}
+
+	switch len(frame.payload) {
+	case beastPayloadLen[beastModeAC]:
+		frame.msgType = beastModeAC
+	case beastPayloadLen[beastModeSShort]:
+		frame.msgType = beastModeSShort
+	case beastPayloadLen[beastModeSLong]:
+		frame.msgType = beastModeSLong
+	default:
+		return beastFrame{}, false
+	}
+
+	return frame, validModeSLength(frame)
+}
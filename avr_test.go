@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_avrReaderFrames(t *testing.T) {
+	klm := []byte{0x8D, 0x48, 0x40, 0xD6, 0x20, 0x2C, 0xC3, 0x71, 0xC3, 0x2C, 0xE0, 0x57, 0x60, 0x98}
+
+	tests := []struct {
+		name       string
+		stream     string
+		msgTypes   []byte
+		payloads   [][]byte
+		timestamps [][]byte
+		malformed  uint64
+	}{
+		{
+			name:       "raw",
+			stream:     "*8D4840D6202CC371C32CE0576098;\n",
+			msgTypes:   []byte{beastModeSLong},
+			payloads:   [][]byte{klm},
+			timestamps: [][]byte{{0, 0, 0, 0, 0, 0}},
+		},
+		{
+			name:       "MLAT timestamp, CRLF and no newline at the end",
+			stream:     "@FF004D4C41548D4840D6202CC371C32CE0576098;\r\n@0000000012345D4840D6F8740F;",
+			msgTypes:   []byte{beastModeSLong, beastModeSShort},
+			payloads:   [][]byte{klm, {0x5D, 0x48, 0x40, 0xD6, 0xF8, 0x74, 0x0F}},
+			timestamps: [][]byte{magicTimestampMLAT, {0, 0, 0, 0, 0x12, 0x34}},
+		},
+		{
+			name:       "signal level and Mode A/C",
+			stream:     "<0000000000012A8D4840D6202CC371C32CE0576098;\n*7700;\n",
+			msgTypes:   []byte{beastModeSLong, beastModeAC},
+			payloads:   [][]byte{klm, {0x77, 0x00}},
+			timestamps: [][]byte{{0, 0, 0, 0, 0, 1}, {0, 0, 0, 0, 0, 0}},
+		},
+		{
+			name: "garbage between frames",
+			stream: "\n*8D4840D6202CC371C32CE0576098\n*8D4840D6202CC371C32CE05760;\n*ZZ4840D6202CC371C32CE0576098;\n" +
+				"#8D4840D6202CC371C32CE0576098;\n*5D4840D6202CC371C32CE0576098;\n" + strings.Repeat("8D", 200) + "\n" +
+				"*8D4840D6202CC371C32CE0576098;\n",
+			msgTypes:   []byte{beastModeSLong},
+			payloads:   [][]byte{klm},
+			timestamps: [][]byte{{0, 0, 0, 0, 0, 0}},
+			malformed:  6,
+		},
+	}
+
+	for _, tc := range tests {
+		reader := newAVRReader(strings.NewReader(tc.stream))
+
+		var msgTypes []byte
+		var payloads, timestamps [][]byte
+		for {
+			frame, err := reader.readFrame()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatalf("%s: unexpected error %v", tc.name, err)
+			}
+			msgTypes = append(msgTypes, frame.msgType)
+			payloads = append(payloads, frame.payload)
+			timestamps = append(timestamps, frame.timestamp)
+		}
+
+		if !bytes.Equal(msgTypes, tc.msgTypes) {
+			t.Fatalf("%s: expected: %x, got: %x", tc.name, tc.msgTypes, msgTypes)
+		}
+		if !reflect.DeepEqual(payloads, tc.payloads) {
+			t.Fatalf("%s: expected: %v, got: %v", tc.name, tc.payloads, payloads)
+		}
+		if !reflect.DeepEqual(timestamps, tc.timestamps) {
+			t.Fatalf("%s: expected: %v, got: %v", tc.name, tc.timestamps, timestamps)
+		}
+		if reader.frameStats().malformed != tc.malformed {
+			t.Fatalf("%s: expected %d malformed, got %d", tc.name, tc.malformed, reader.frameStats().malformed)
+		}
+	}
+}
+
+func Test_avrReaderSignal(t *testing.T) {
+	reader := newAVRReader(strings.NewReader("<0000000000012A8D4840D6202CC371C32CE0576098;\n"))
+
+	frame, err := reader.readFrame()
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if frame.signal != 0x2A {
+		t.Fatalf("expected signal 0x2A, got %x", frame.signal)
+	}
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bufio"
+	"io"
+
+	"github.com/jsmithedin/overmyhouse/pkg/modes"
+)
+
+// https://github.com/firestuff/adsb-tools/blob/master/protocols/beast.md
+const beastEscape = 0x1A
+
+const (
+	beastModeAC     = 0x31
+	beastModeSShort = 0x32
+	beastModeSLong  = 0x33
+	beastStatus     = 0x34
+)
+
+// Every frame carries a 6 byte timestamp and a 1 byte signal level ahead of the payload
+const beastHeaderLen = 7
+
+var beastPayloadLen = map[byte]int{
+	beastModeAC:     2,
+	beastModeSShort: 7,
+	beastModeSLong:  14,
+	beastStatus:     14,
+}
+
+type beastFrame struct {
+	msgType   byte
+	timestamp []byte
+	signal    byte
+	payload   []byte
+}
+
+type beastStats struct {
+	frames    uint64
+	dropped   uint64
+	malformed uint64
+}
+
+// frameReader splits a stream into frames, whatever format it is in
+type frameReader interface {
+	readFrame() (beastFrame, error)
+	frameStats() beastStats
+}
+
+// beastReader splits a BEAST byte stream into frames, undoing the 0x1A byte
+// stuffing and resynchronising on the next frame start after corruption.
+type beastReader struct {
+	r *bufio.Reader
+
+	// Type byte of a frame that began before the previous one was complete
+	pendingType byte
+	hasPending  bool
+
+	stats beastStats
+}
+
+func newBeastReader(r io.Reader) *beastReader {
+	return &beastReader{r: bufio.NewReader(r)}
+}
+
+// readFrame returns the next well formed frame in the stream. Dropped and
+// malformed frames are skipped and counted rather than returned.
+func (b *beastReader) readFrame() (beastFrame, error) {
+	for {
+		msgType, err := b.nextFrameType()
+		if err != nil {
+			return beastFrame{}, err
+		}
+
+		payloadLen, ok := beastPayloadLen[msgType]
+		if !ok {
+			b.stats.dropped++
+			continue
+		}
+
+		buf, err := b.readEscaped(beastHeaderLen + payloadLen)
+		if err != nil {
+			b.stats.malformed++
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return beastFrame{}, err
+		}
+		if buf == nil {
+			// Cut short by the start of another frame
+			b.stats.malformed++
+			continue
+		}
+
+		frame := beastFrame{
+			msgType:   msgType,
+			timestamp: buf[0:6],
+			signal:    buf[6],
+			payload:   buf[beastHeaderLen:],
+		}
+
+		if !validModeSLength(frame) {
+			b.stats.malformed++
+			continue
+		}
+
+		b.stats.frames++
+		return frame, nil
+	}
+}
+
+func (b *beastReader) frameStats() beastStats {
+	return b.stats
+}
+
+// nextFrameType discards bytes until an unescaped 0x1A and returns the frame
+// type that follows it.
+func (b *beastReader) nextFrameType() (byte, error) {
+	if b.hasPending {
+		b.hasPending = false
+		return b.pendingType, nil
+	}
+
+	skipped := false
+	for {
+		c, err := b.r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		if c != beastEscape {
+			skipped = true
+			continue
+		}
+
+		msgType, err := b.r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		if msgType == beastEscape {
+			// An escaped data byte, we are still inside something we lost the start of
+			skipped = true
+			continue
+		}
+
+		if skipped {
+			b.stats.dropped++
+		}
+		return msgType, nil
+	}
+}
+
+// readEscaped reads n unescaped bytes. It returns a nil slice if an unescaped
+// 0x1A shows up first, leaving the type of the new frame pending.
+func (b *beastReader) readEscaped(n int) ([]byte, error) {
+	buf := make([]byte, n)
+	for i := range buf {
+		c, err := b.r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		if c == beastEscape {
+			next, err := b.r.ReadByte()
+			if err != nil {
+				return nil, err
+			}
+			if next != beastEscape {
+				b.pendingType = next
+				b.hasPending = true
+				return nil, nil
+			}
+		}
+		buf[i] = c
+	}
+	return buf, nil
+}
+
+// validModeSLength checks the downlink format agrees with the frame size
+func validModeSLength(frame beastFrame) bool {
+	switch frame.msgType {
+	case beastModeSShort, beastModeSLong:
+		return len(frame.payload) == modes.FrameLength(frame.payload[0]>>3)
+	}
+	return true
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"reflect"
+	"testing"
+)
+
+var testLongPayload = []byte{141, 64, 115, 119, 33, 52, 66, 112, 226, 8, 32, 34, 235, 246}
+
+func Test_beastReaderFrames(t *testing.T) {
+	tests := []struct {
+		name      string
+		stream    []byte
+		payloads  [][]byte
+		dropped   uint64
+		malformed uint64
+	}{
+		{
+			name:     "single long frame",
+			stream:   append([]byte{0x1A, 0x33, 1, 2, 3, 4, 5, 6, 7}, testLongPayload...),
+			payloads: [][]byte{testLongPayload},
+		},
+		{
+			name:     "escaped timestamp and payload",
+			stream:   []byte{0x1A, 0x32, 0x1A, 0x1A, 2, 3, 4, 5, 6, 0x1A, 0x1A, 0x5D, 0x1A, 0x1A, 2, 3, 4, 5, 6},
+			payloads: [][]byte{{0x5D, 0x1A, 2, 3, 4, 5, 6}},
+		},
+		{
+			name:     "garbage before frame",
+			stream:   append([]byte{0xFF, 0x00, 0x1A, 0x1A, 0x12, 0x1A, 0x33, 1, 2, 3, 4, 5, 6, 7}, testLongPayload...),
+			payloads: [][]byte{testLongPayload},
+			dropped:  1,
+		},
+		{
+			name:     "unknown frame type",
+			stream:   append([]byte{0x1A, 0x39, 0x1A, 0x33, 1, 2, 3, 4, 5, 6, 7}, testLongPayload...),
+			payloads: [][]byte{testLongPayload},
+			dropped:  1,
+		},
+		{
+			name:      "truncated by next frame",
+			stream:    append([]byte{0x1A, 0x33, 1, 2, 3, 0x1A, 0x33, 1, 2, 3, 4, 5, 6, 7}, testLongPayload...),
+			payloads:  [][]byte{testLongPayload},
+			malformed: 1,
+		},
+		{
+			name:      "short DF in long frame",
+			stream:    []byte{0x1A, 0x33, 1, 2, 3, 4, 5, 6, 7, 0x5D, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13},
+			malformed: 1,
+		},
+		{
+			name:      "truncated by end of stream",
+			stream:    []byte{0x1A, 0x33, 1, 2, 3},
+			malformed: 1,
+		},
+	}
+
+	for _, tc := range tests {
+		reader := newBeastReader(bytes.NewReader(tc.stream))
+
+		var payloads [][]byte
+		for {
+			frame, err := reader.readFrame()
+			if err != nil {
+				if err != io.EOF && err != io.ErrUnexpectedEOF {
+					t.Fatalf("%s: unexpected error %v", tc.name, err)
+				}
+				break
+			}
+			payloads = append(payloads, frame.payload)
+		}
+
+		if !reflect.DeepEqual(payloads, tc.payloads) {
+			t.Fatalf("%s: expected: %v, got: %v", tc.name, tc.payloads, payloads)
+		}
+		if reader.stats.dropped != tc.dropped {
+			t.Fatalf("%s: expected %d dropped, got %d", tc.name, tc.dropped, reader.stats.dropped)
+		}
+		if reader.stats.malformed != tc.malformed {
+			t.Fatalf("%s: expected %d malformed, got %d", tc.name, tc.malformed, reader.stats.malformed)
+		}
+	}
+}
+
+func Test_beastReaderHeader(t *testing.T) {
+	stream := append([]byte{0x1A, 0x33, 0xFF, 0x00, 0x4D, 0x4C, 0x41, 0x54, 0x1A, 0x1A}, testLongPayload...)
+	reader := newBeastReader(bytes.NewReader(stream))
+
+	frame, err := reader.readFrame()
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if !bytes.Equal(frame.timestamp, magicTimestampMLAT) {
+		t.Fatalf("expected: %v, got: %v", magicTimestampMLAT, frame.timestamp)
+	}
+	if frame.signal != 0x1A {
+		t.Fatalf("expected signal 0x1A, got %x", frame.signal)
+	}
+	if reader.stats.frames != 1 {
+		t.Fatalf("expected 1 frame, got %d", reader.stats.frames)
+	}
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math/rand"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/coreos/go-systemd/daemon"
+)
+
+// clientState is where a feedClient is in connecting to its feeders
+type clientState uint8
+
+const (
+	clientIdle clientState = iota
+	clientConnecting
+	clientConnected
+	clientDisconnected
+	clientBackingOff
+	clientStopped
+)
+
+var clientStateNames = []string{"idle", "connecting", "connected", "disconnected", "backing off", "stopped"}
+
+func (state clientState) String() string {
+	if int(state) < len(clientStateNames) {
+		return clientStateNames[state]
+	}
+	return ""
+}
+
+const (
+	// Backoff after the first round of failed connections, doubling each
+	// round up to maxClientBackoff
+	minClientBackoff = time.Second
+	maxClientBackoff = time.Minute
+	// A connection that lasts this long resets the backoff
+	stableConnection = 30 * time.Second
+)
+
+// feedClient keeps a connection open to the first of an ordered list of
+// feeders that will have us. When a connection drops it goes back to the top
+// of the list, and once every feeder has failed it waits, for twice as long
+// each time, before trying them all again.
+type feedClient struct {
+	name    string
+	feeders []string
+	// How long a connection can go without any data before it is given up on,
+	// a feeder that reboots doesn't always close its connections
+	idleTimeout time.Duration
+
+	dial  func(address string) (net.Conn, error)
+	sleep func(delay time.Duration, quit <-chan struct{}) bool
+
+	state   clientState
+	backoff time.Duration
+}
+
+// newFeedClient makes a client for a comma separated list of feeders, the
+// primary first
+func newFeedClient(name string, feeders string, idleTimeout time.Duration) *feedClient {
+	client := &feedClient{
+		name:        name,
+		idleTimeout: idleTimeout,
+		dial: func(address string) (net.Conn, error) {
+			return net.DialTimeout("tcp", address, 10*time.Second)
+		},
+		sleep: func(delay time.Duration, quit <-chan struct{}) bool {
+			select {
+			case <-time.After(delay):
+				return true
+			case <-quit:
+				return false
+			}
+		},
+	}
+	for _, feeder := range strings.Split(feeders, ",") {
+		if feeder = strings.TrimSpace(feeder); feeder != "" {
+			client.feeders = append(client.feeders, feeder)
+		}
+	}
+	return client
+}
+
+// run connects and hands each connection to handle, which returns when the
+// connection ends, until quit is closed
+func (client *feedClient) run(handle func(conn net.Conn), quit <-chan struct{}) {
+	for {
+		for _, feeder := range client.feeders {
+			select {
+			case <-quit:
+				client.transition(clientStopped, feeder, nil)
+				return
+			default:
+			}
+
+			client.transition(clientConnecting, feeder, nil)
+			conn, err := client.dial(feeder)
+			if err != nil {
+				client.transition(clientDisconnected, feeder, err)
+				continue
+			}
+
+			client.transition(clientConnected, feeder, nil)
+			connected := time.Now()
+			if client.idleTimeout > 0 {
+				conn = &idleTimeoutConn{Conn: conn, timeout: client.idleTimeout}
+			}
+			handle(conn)
+			conn.Close()
+			client.transition(clientDisconnected, feeder, nil)
+
+			if time.Since(connected) >= stableConnection {
+				client.backoff = 0
+			}
+			// Start again from the primary
+			break
+		}
+
+		delay := client.nextBackoff()
+		client.transition(clientBackingOff, fmt.Sprintf("%v", delay.Round(time.Millisecond)), nil)
+		if !client.sleep(delay, quit) {
+			client.transition(clientStopped, "", nil)
+			return
+		}
+	}
+}
+
+// nextBackoff doubles the backoff and returns somewhere between half and all
+// of it, so that clients restarted together don't reconnect together
+func (client *feedClient) nextBackoff() time.Duration {
+	switch {
+	case client.backoff == 0:
+		client.backoff = minClientBackoff
+	case client.backoff < maxClientBackoff:
+		client.backoff *= 2
+		if client.backoff > maxClientBackoff {
+			client.backoff = maxClientBackoff
+		}
+	}
+	return client.backoff/2 + time.Duration(rand.Int63n(int64(client.backoff/2)+1))
+}
+
+// transition logs a change of state and tells systemd about it
+func (client *feedClient) transition(state clientState, detail string, err error) {
+	message := fmt.Sprintf("Feeder client %s: %v -> %v", client.name, client.state, state)
+	if detail != "" {
+		message += " " + detail
+	}
+	if err != nil {
+		message += fmt.Sprintf(": %v", err)
+	}
+	client.state = state
+
+	log.Println(message)
+	_, _ = daemon.SdNotify(false, "STATUS="+message)
+}
+
+// idleTimeoutConn fails a read that waits longer than timeout for data
+type idleTimeoutConn struct {
+	net.Conn
+	timeout time.Duration
+}
+
+func (conn *idleTimeoutConn) Read(b []byte) (int, error) {
+	if err := conn.Conn.SetReadDeadline(time.Now().Add(conn.timeout)); err != nil {
+		return 0, err
+	}
+	return conn.Conn.Read(b)
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// testFeedClient fakes the network: feeders in up accept a connection that
+// closes straight away, anything else refuses. It stops after rounds backoffs.
+func testFeedClient(feeders string, up map[string]bool, rounds int) (*feedClient, *[]string, *[]time.Duration) {
+	var dialled []string
+	var delays []time.Duration
+
+	client := newFeedClient("test", feeders, 0)
+	client.dial = func(address string) (net.Conn, error) {
+		dialled = append(dialled, address)
+		if !up[address] {
+			return nil, errors.New("connection refused")
+		}
+		server, conn := net.Pipe()
+		server.Close()
+		return conn, nil
+	}
+	client.sleep = func(delay time.Duration, quit <-chan struct{}) bool {
+		delays = append(delays, delay)
+		return len(delays) < rounds
+	}
+	return client, &dialled, &delays
+}
+
+func TestFeedClientFailover(t *testing.T) {
+	client, dialled, _ := testFeedClient("primary:30005, backup:30005,spare:30005", map[string]bool{"backup:30005": true}, 2)
+
+	handled := 0
+	client.run(func(conn net.Conn) {
+		if conn == nil {
+			t.Fatalf("expected a connection")
+		}
+		handled++
+	}, nil)
+
+	want := []string{"primary:30005", "backup:30005", "primary:30005", "backup:30005"}
+	if !reflect.DeepEqual(*dialled, want) {
+		t.Fatalf("expected: %v, got: %v", want, *dialled)
+	}
+	if handled != 2 {
+		t.Fatalf("expected: 2, got: %v", handled)
+	}
+	if client.state != clientStopped {
+		t.Fatalf("expected: %v, got: %v", clientStopped, client.state)
+	}
+}
+
+func TestFeedClientBackoff(t *testing.T) {
+	client, dialled, delays := testFeedClient("primary:30005,backup:30005", nil, 10)
+	client.run(func(conn net.Conn) {
+		t.Fatalf("expected no connection")
+	}, nil)
+
+	if len(*dialled) != 20 {
+		t.Fatalf("expected both feeders to be tried each round, got: %v", *dialled)
+	}
+
+	backoff := minClientBackoff
+	for i, delay := range *delays {
+		if delay < backoff/2 || delay > backoff {
+			t.Fatalf("round %d: expected between %v and %v, got: %v", i, backoff/2, backoff, delay)
+		}
+		if backoff *= 2; backoff > maxClientBackoff {
+			backoff = maxClientBackoff
+		}
+	}
+}
+
+func TestFeedClientQuit(t *testing.T) {
+	client, dialled, _ := testFeedClient("primary:30005", map[string]bool{"primary:30005": true}, 10)
+	quit := make(chan struct{})
+	close(quit)
+
+	client.run(func(conn net.Conn) {}, quit)
+
+	if len(*dialled) != 0 || client.state != clientStopped {
+		t.Fatalf("expected to stop without dialling, got: %v %v", *dialled, client.state)
+	}
+}
+
+func TestIdleTimeoutConn(t *testing.T) {
+	server, client := net.Pipe()
+	defer server.Close()
+	conn := &idleTimeoutConn{Conn: client, timeout: 50 * time.Millisecond}
+
+	go func() { _, _ = server.Write([]byte{0x1A}) }()
+	b := make([]byte, 1)
+	if _, err := conn.Read(b); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := conn.Read(b)
+	var netErr net.Error
+	if !errors.As(err, &netErr) || !netErr.Timeout() {
+		t.Fatalf("expected a timeout, got: %v", err)
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"math"
+	"time"
+
+	"github.com/jsmithedin/overmyhouse/pkg/modes"
+)
+
+// timedValue is a decoded value along with when it was last updated. A zero
+// time means we have never had one.
+type timedValue struct {
+	value   float64
+	updated time.Time
+}
+
+func (v *timedValue) set(value float64, now time.Time) {
+	v.value = value
+	v.updated = now
+}
+
+// commBData holds what we have learned from enhanced surveillance replies
+type commBData struct {
+	selectedAltitude  timedValue // ft, MCP/FCU
+	fmsAltitude       timedValue // ft
+	baroSetting       timedValue // mb
+	rollAngle         timedValue // degrees, negative is left wing down
+	trueTrack         timedValue // degrees
+	trueAirspeed      timedValue // kt
+	magHeading        timedValue // degrees
+	indicatedAirspeed timedValue // kt
+	mach              timedValue
+	baroVertRate      timedValue // ft/min
+	inertialVertRate  timedValue // ft/min
+}
+
+// applyCommB copies what a Comm-B register told us onto the aircraft
+func applyCommB(register *modes.CommB, aircraft *aircraftData, now time.Time) {
+	if register == nil {
+		return
+	}
+
+	if register.Callsign != "" {
+		aircraft.callsign = register.Callsign
+	}
+
+	commB := &aircraft.commB
+	for _, field := range []struct {
+		value float64
+		dest  *timedValue
+	}{
+		{register.SelectedAltitude, &commB.selectedAltitude},
+		{register.FMSAltitude, &commB.fmsAltitude},
+		{register.BaroSetting, &commB.baroSetting},
+		{register.RollAngle, &commB.rollAngle},
+		{register.TrueTrack, &commB.trueTrack},
+		{register.TrueAirspeed, &commB.trueAirspeed},
+		{register.MagHeading, &commB.magHeading},
+		{register.IndicatedAirspeed, &commB.indicatedAirspeed},
+		{register.Mach, &commB.mach},
+		{register.BaroVertRate, &commB.baroVertRate},
+		{register.InertialVertRate, &commB.inertialVertRate},
+	} {
+		if field.value != math.MaxFloat64 {
+			field.dest.set(field.value, now)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/jsmithedin/overmyhouse/pkg/modes"
+)
+
+var (
+	testBDS20 = []byte{160, 0, 8, 62, 32, 44, 195, 113, 195, 29, 224, 170, 28, 207}
+	testBDS40 = []byte{160, 0, 2, 156, 133, 228, 47, 49, 48, 0, 0, 112, 71, 211}
+	testBDS50 = []byte{160, 0, 19, 147, 129, 149, 21, 54, 224, 36, 212, 204, 246, 181}
+	testBDS60 = []byte{160, 0, 4, 18, 143, 57, 249, 26, 126, 39, 196, 106, 220, 33}
+)
+
+func Test_applyCommB(t *testing.T) {
+	now := time.Now()
+	testAircraft := newAircraftData(0, false)
+
+	for _, message := range [][]byte{testBDS20, testBDS40, testBDS50, testBDS60} {
+		decoded, err := modes.Decode(message)
+		if err != nil {
+			t.Fatal(err)
+		}
+		applyCommB(decoded.(*modes.AltitudeReply).CommB, &testAircraft, now)
+	}
+
+	if testAircraft.callsign != "KLM1017 " {
+		t.Fatalf("expected: KLM1017, got: %v", testAircraft.callsign)
+	}
+
+	commB := testAircraft.commB
+	tests := []struct {
+		name  string
+		field timedValue
+		want  float64
+	}{
+		{name: "selected altitude", field: commB.selectedAltitude, want: 3008},
+		{name: "FMS altitude", field: commB.fmsAltitude, want: 3008},
+		{name: "baro setting", field: commB.baroSetting, want: 1020},
+		{name: "roll angle", field: commB.rollAngle, want: 2.1},
+		{name: "true track", field: commB.trueTrack, want: 114.258},
+		{name: "true airspeed", field: commB.trueAirspeed, want: 424},
+		{name: "magnetic heading", field: commB.magHeading, want: 42.715},
+		{name: "indicated airspeed", field: commB.indicatedAirspeed, want: 252},
+		{name: "mach", field: commB.mach, want: 0.42},
+		{name: "baro vertical rate", field: commB.baroVertRate, want: -1920},
+		{name: "inertial vertical rate", field: commB.inertialVertRate, want: -1920},
+	}
+
+	for _, tc := range tests {
+		if !tc.field.updated.Equal(now) {
+			t.Fatalf("%s: expected update time to be set", tc.name)
+		}
+		if math.Abs(tc.field.value-tc.want) > 0.01 {
+			t.Fatalf("%s: expected: %v, got: %v", tc.name, tc.want, tc.field.value)
+		}
+	}
+}
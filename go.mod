@@ -1,6 +1,6 @@
 module github.com/jsmithedin/overmyhouse
 
-go 1.13
+go 1.18
 
 require (
 	github.com/coreos/go-systemd v0.0.0-20190719114852-fd7a80b32e1f
@@ -9,3 +9,9 @@ require (
 	github.com/joho/godotenv v1.5.1
 	gopkg.in/natefinch/lumberjack.v2 v2.2.1
 )
+
+require (
+	github.com/cenkalti/backoff v2.1.1+incompatible // indirect
+	github.com/dghubble/sling v1.3.0 // indirect
+	github.com/google/go-querystring v1.0.0 // indirect
+)
@@ -0,0 +1,12 @@
+package main
+
+// parseModeAC tracks a Mode A/C reply under a pseudo address made from the
+// reply code. A reply the decoder turns down comes back as a *modes.FrameError.
+func parseModeAC(message []byte, knownAircraft *KnownAircraft) error {
+	decoded, err := modeSDecoder.Decode(message)
+	if err != nil {
+		return err
+	}
+	trackMessage(decoded, false, knownAircraft)
+	return nil
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func Test_parseModeAC(t *testing.T) {
+	testKnownAircraft := &KnownAircraft{}
+
+	tests := []struct {
+		message  []byte
+		icaoAddr uint32
+		squawk   uint16
+		altitude int32
+		number   int
+	}{
+		{message: []byte{0x77, 0x00}, icaoAddr: modeACAddrFlag | 0x7700, squawk: 0x7700, altitude: math.MaxInt32, number: 1},
+		{message: []byte{0x44, 0x10}, icaoAddr: modeACAddrFlag | 0x4410, squawk: 0x4410, altitude: 6200, number: 2},
+		{message: []byte{0x44, 0x90}, icaoAddr: modeACAddrFlag | 0x4410, squawk: 0x4410, altitude: 6200, number: 2},
+	}
+
+	for _, tc := range tests {
+		parseModeAC(tc.message, testKnownAircraft)
+
+		aircraft, known := testKnownAircraft.getAircraft(tc.icaoAddr)
+		if !known {
+			t.Fatalf("expected %x to be known", tc.icaoAddr)
+		}
+		if aircraft.squawk != tc.squawk {
+			t.Fatalf("expected: %04x, got: %04x", tc.squawk, aircraft.squawk)
+		}
+		if aircraft.altitude != tc.altitude {
+			t.Fatalf("expected: %v, got: %v", tc.altitude, aircraft.altitude)
+		}
+		if testKnownAircraft.getNumberOfKnown() != tc.number {
+			t.Fatalf("expected: %v, got: %v", tc.number, testKnownAircraft.getNumberOfKnown())
+		}
+	}
+}
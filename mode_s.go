@@ -2,74 +2,221 @@ package main
 
 import (
 	"encoding/binary"
+	"errors"
 	"math"
 	"time"
+
+	"github.com/jsmithedin/overmyhouse/pkg/modes"
 )
 
-func parseModeS(message []byte, isMlat bool, knownAircraft *KnownAircraft) {
-	// https://en.wikipedia.org/wiki/Secondary_surveillance_radar#Mode_S
-	// https://github.com/mutability/dump1090/blob/master/mode_s.c
-	linkFmt := uint((message[0] & 0xF8) >> 3)
+// modeSDecoder decodes every frame we are fed, so its parity check counts
+// cover them all
+var modeSDecoder = &modes.Decoder{FixErrors: 1}
+
+// parseModeS decodes a Mode S frame and tracks the aircraft it is from,
+// returning how far away it is if the frame gave it a new position, otherwise
+// math.MaxFloat64. A frame the decoder turns down comes back as a
+// *modes.FrameError.
+func parseModeS(message []byte, isMlat bool, knownAircraft *KnownAircraft) (float64, error) {
+	decoded, err := modeSDecoder.Decode(message)
+	if err != nil {
+		return math.MaxFloat64, err
+	}
+	return trackMessage(decoded, isMlat, knownAircraft), nil
+}
 
-	var aircraft aircraftData
-	var aircraftExists bool
-	icaoAddr := uint32(math.MaxUint32)
-	altitude := int32(math.MaxInt32)
+// decodeStats counts the frames on a connection the decoder turned down
+type decodeStats struct {
+	length      uint64
+	crc         uint64
+	unsupported uint64
+}
 
-	if linkFmt == 11 || linkFmt == 17 || linkFmt == 18 {
-		icaoAddr = uint32(message[1])*65536 + uint32(message[2])*256 + uint32(message[3])
+func (stats *decodeStats) count(err error) {
+	switch {
+	case errors.Is(err, modes.ErrLength):
+		stats.length++
+	case errors.Is(err, modes.ErrCRC):
+		stats.crc++
+	case errors.Is(err, modes.ErrUnsupported):
+		stats.unsupported++
 	}
+}
 
-	if icaoAddr != math.MaxUint32 {
-		var ptrAircraft *aircraftData
-		ptrAircraft, aircraftExists = knownAircraft.getAircraft(icaoAddr)
-		if !aircraftExists {
-			// initialize some values
-			aircraft = aircraftData{
-				icaoAddr:  icaoAddr,
-				oRawLat:   math.MaxUint32,
-				oRawLon:   math.MaxUint32,
-				eRawLat:   math.MaxUint32,
-				eRawLon:   math.MaxUint32,
-				latitude:  math.MaxFloat64,
-				longitude: math.MaxFloat64,
-				altitude:  math.MaxInt32,
-				callsign:  "",
-				mlat:      isMlat}
-		} else {
-			aircraft = (*ptrAircraft)
-			aircraft.mlat = isMlat
-		}
+// trackMessage updates the aircraft a decoded message is from, adding it if
+// we haven't heard from it before. If the message moved the aircraft it
+// returns the distance to it, otherwise math.MaxFloat64.
+func trackMessage(message modes.Message, isMlat bool, knownAircraft *KnownAircraft) (distance float64) {
+	header := message.MessageHeader()
+	distance = math.MaxFloat64
+
+	icaoAddr := trackedAddress(header, knownAircraft)
+	if icaoAddr == math.MaxUint32 {
+		return distance
+	}
+
+	knownAircraft.Update(icaoAddr, func(aircraft *aircraftData) {
+		lastPos := aircraft.lastPos
+		aircraft.mlat = isMlat
 		aircraft.lastPing = time.Now()
+		source := header.Source
+		if isMlat {
+			source = modes.SourceMLAT
+		}
+		aircraft.setSource(source, aircraft.lastPing)
+		if header.AddressType != modes.AddressModeA &&
+			(header.DF == 11 || header.DF == 17 || header.DF == 18) {
+			aircraft.lastSquitter = aircraft.lastPing
+		}
+
+		applyMessage(message, aircraft)
+		if !aircraft.lastPos.Equal(lastPos) {
+			distance = aircraft.distance
+		}
+	})
+	return distance
+}
+
+// trackedAddress gives the address we keep an aircraft under, with the
+// address spaces that aren't ICAO addresses kept apart by a flag bit.
+func trackedAddress(header modes.Header, knownAircraft *KnownAircraft) uint32 {
+	switch header.AddressType {
+	case modes.AddressICAO:
+		return header.Address
+	case modes.AddressParity:
+		return recoverAPAddress(header.Address, knownAircraft)
+	case modes.AddressAnonymous:
+		return header.Address | anonAddrFlag
+	case modes.AddressNonICAO:
+		return header.Address | nonICAOAddrFlag
+	case modes.AddressModeA:
+		return header.Address | modeACAddrFlag
 	}
+	return math.MaxUint32
+}
+
+// applyMessage copies what a message told us onto the aircraft
+func applyMessage(message modes.Message, aircraft *aircraftData) {
+	now := time.Now()
+
+	switch message := message.(type) {
+	case *modes.ModeAC:
+		aircraft.squawk = message.Squawk
+		if message.Altitude != math.MaxInt32 {
+			aircraft.altitude = message.Altitude
+		}
+
+	case *modes.AltitudeReply:
+		if message.Altitude != math.MaxInt32 {
+			aircraft.altitude = message.Altitude
+		}
+		applyCommB(message.CommB, aircraft, now)
+
+	case *modes.IdentityReply:
+		aircraft.squawk = message.Squawk
+		applyCommB(message.CommB, aircraft, now)
+
+	case *modes.Identification:
+		if message.Callsign != "" {
+			aircraft.callsign = message.Callsign
+		}
+		if message.Category != 0 {
+			aircraft.category = message.Category
+		}
+
+	case *modes.Velocity:
+		applyVelocity(message, aircraft)
 
-	if linkFmt == 0 || linkFmt == 4 || linkFmt == 16 || linkFmt == 20 {
-		// Altitude: 13 bit signal
-		altCode := (uint16(message[2])*256 + uint16(message[3])) & 0x1FFF
+	case *modes.TargetState:
+		applyTargetState(message, aircraft, now)
 
-		if (altCode & 0x0040) > 0 {
-			// meters
-			// TODO
+	case *modes.OperationalStatus:
+		applyOperationalStatus(message, aircraft, now)
 
-		} else if (altCode & 0x0010) > 0 {
-			// feet, raw integer
-			ac := (altCode&0x1F80)>>2 + (altCode&0x0020)>>1 + (altCode & 0x000F)
-			altitude = int32((ac * 25) - 1000)
+	case *modes.EmergencyStatus:
+		aircraft.emergency = message.Emergency
+		aircraft.squawk = message.Squawk
 
+	case *modes.SurfacePosition:
+		if message.GroundSpeed != math.MaxFloat64 {
+			aircraft.groundSpeed = message.GroundSpeed
 		}
+		if message.Track != math.MaxFloat64 {
+			aircraft.track = message.Track
+		}
+		applyPosition(aircraft, message.CPR, true, now)
 
-		if altitude != math.MaxInt32 {
-			aircraft.altitude = altitude
+	case *modes.AirbornePosition:
+		if message.Altitude != math.MaxInt32 {
+			aircraft.altitude = message.Altitude
 		}
+		applyPositionIntegrity(message, aircraft)
+		applyPosition(aircraft, message.CPR, false, now)
+	}
+}
+
+func applyVelocity(message *modes.Velocity, aircraft *aircraftData) {
+	if message.GroundSpeed != math.MaxFloat64 {
+		aircraft.groundSpeed = message.GroundSpeed
+		aircraft.track = message.Track
+	}
+	if message.Heading != math.MaxFloat64 {
+		aircraft.heading = message.Heading
+	}
+	if message.Airspeed != math.MaxInt32 {
+		aircraft.airspeed = message.Airspeed
+		aircraft.airspeedTrue = message.AirspeedTrue
+	}
+	if message.VertRate != math.MaxInt32 {
+		aircraft.vertRate = message.VertRate
+		aircraft.vertRateGNSS = message.VertRateGNSS
+	}
+	if message.GNSSBaroDiff != math.MaxInt32 {
+		aircraft.gnssBaroDiff = message.GNSSBaroDiff
+	}
+}
+
+// applyPosition decodes a CPR position and takes it if it is believable
+func applyPosition(aircraft *aircraftData, cpr modes.CPR, surface bool, now time.Time) {
+	latitude, longitude := setPositions(aircraft, cpr, surface)
+
+	if latitude != math.MaxFloat64 && longitude != math.MaxFloat64 {
+		acceptPosition(aircraft, latitude, longitude, now)
 	}
+}
 
-	if linkFmt == 17 || linkFmt == 18 {
-		decodeExtendedSquitter(message, &aircraft)
+// acceptPosition moves the aircraft to a position it had at the given time,
+// and adds it to its track, unless it couldn't have got there. A fix no newer
+// than the one we have, such as a decoded feed reporting late, is ignored
+// rather than counted against the aircraft.
+func acceptPosition(aircraft *aircraftData, latitude float64, longitude float64, at time.Time) bool {
+	if !at.After(aircraft.lastPos) {
+		return false
+	}
+	if !plausiblePosition(aircraft, latitude, longitude, at) {
+		return false
 	}
 
-	if icaoAddr != math.MaxUint32 {
-		knownAircraft.addAircraft(icaoAddr, &aircraft)
+	aircraft.setPosition(latitude, longitude)
+	aircraft.lastPos = at
+	aircraft.recordTrack(at)
+	return true
+}
+
+// How long after its last all-call reply or extended squitter an address
+// will still be accepted from an address/parity frame
+const apAddressTimeout = 60 * time.Second
+
+// recoverAPAddress checks the address overlaid on the parity of a
+// DF0/4/5/16/20/21 reply. Any corruption also lands in the address, so it is
+// only trusted if it matches an aircraft that has recently announced itself.
+func recoverAPAddress(icaoAddr uint32, knownAircraft *KnownAircraft) uint32 {
+	lastSquitter, aircraftExists := knownAircraft.lastSquitter(icaoAddr)
+	if !aircraftExists || time.Since(lastSquitter) > apAddressTimeout {
+		return math.MaxUint32
 	}
+
+	return icaoAddr
 }
 
 const (
@@ -110,199 +257,156 @@ func parseTime(timebytes []byte, utcDate time.Time) time.Time {
 	)
 }
 
-func decodeExtendedSquitter(message []byte, aircraft *aircraftData) {
-	var callsign string
-
-	msgType := uint(message[4]) >> 3
-
-	var msgSubType uint
-	if msgType == 29 {
-		msgSubType = (uint(message[4]) & 6) >> 1
-	} else {
-		msgSubType = uint(message[4]) & 7
-	}
-
-	rawLatitude := uint32(math.MaxUint32)
-	rawLongitude := uint32(math.MaxUint32)
-	altitude := int32(math.MaxInt32)
+// Longest gap between the even and odd frames of a pair used for global decoding
+const cprPairWindow = 10 * time.Second
 
-	switch msgType {
-	case 1, 2, 3, 4:
-		// Aircraft ID
-		callsign = decodeCallsign(&message)
+// Fastest we believe anything we can hear is moving, in knots. If the
+// aircraft has told us its ground speed we allow for a bit more than that.
+const (
+	maxAirborneSpeed = 1000.0
+	maxSurfaceSpeed  = 150.0
+	knotsInMps       = 0.514444
+)
 
-	//case 19:
-	//  // Airborne Velocity
+// Allowance for CPR resolution and time spent in the pipeline
+const positionSlackMeters = 500.0
 
-	case 5, 6, 7, 8:
-		// Ground position
-		rawLatitude = uint32(message[6])&3<<15 + uint32(message[7])<<7 +
-			uint32(message[8])>>1
-		rawLongitude = uint32(message[8])&1<<16 + uint32(message[9])<<8 +
-			uint32(message[10])
+// After this many positions in a row fail the speed check the last good one
+// is probably the bad one, so it is dropped and the next decode starts afresh
+const maxPositionRejects = 3
 
-	case 9, 10, 11, 12, 13, 14, 15, 16, 17, 18, 20, 21, 22:
-		// Airborne position
-		rawLatitude, rawLongitude, altitude = decodeAirbornePosition(&message, msgType)
+// plausiblePosition checks a decoded position is within range of the receiver
+// and that the aircraft could have got there from its last position.
+func plausiblePosition(aircraft *aircraftData, latitude float64, longitude float64, now time.Time) bool {
+	if metersInMiles(GreatCircle(latitude, longitude, *baseLat, *baseLon)) > *maxRange {
+		return false
 	}
 
-	latitude, longitude := setPositions(&message, aircraft, rawLatitude, rawLongitude)
-
-	switch msgSubType {
-	case 1:
-		break
+	if aircraft.latitude == math.MaxFloat64 || aircraft.longitude == math.MaxFloat64 ||
+		aircraft.lastPos.IsZero() {
+		return true
 	}
 
-	if callsign != "" {
-		aircraft.callsign = callsign
-	}
-	if altitude != math.MaxInt32 {
-		aircraft.altitude = altitude
+	maxSpeed := maxAirborneSpeed
+	if aircraft.onGround {
+		maxSpeed = maxSurfaceSpeed
 	}
-	if latitude != math.MaxFloat64 && longitude != math.MaxFloat64 {
-		aircraft.latitude = latitude
-		aircraft.longitude = longitude
-		aircraft.lastPos = time.Now()
+	if aircraft.groundSpeed != math.MaxFloat64 {
+		maxSpeed = math.Min(maxSpeed, aircraft.groundSpeed*1.5+50)
 	}
-}
 
-func parseRawLatLon(evenLat uint32, evenLon uint32, oddLat uint32,
-	oddLon uint32, lastOdd bool, tFlag bool) (latitude float64, longitude float64) {
-	if evenLat == math.MaxUint32 || oddLat == math.MaxUint32 ||
-		evenLon == math.MaxUint32 || oddLon == math.MaxUint32 {
-		return math.MaxFloat64, math.MaxFloat64
+	elapsed := now.Sub(aircraft.lastPos).Seconds()
+	allowed := maxSpeed*knotsInMps*elapsed + positionSlackMeters
+
+	if GreatCircle(latitude, longitude, aircraft.latitude, aircraft.longitude) > allowed {
+		aircraft.posRejects++
+		if aircraft.posRejects >= maxPositionRejects {
+			aircraft.setPosition(math.MaxFloat64, math.MaxFloat64)
+			aircraft.posRejects = 0
+		}
+		return false
 	}
 
-	// http://www.lll.lu/~edward/edward/adsb/DecodingADSBposition.html
-	j := int32((float64(59*evenLat-60*oddLat) / 131072.0) + 0.5)
+	aircraft.posRejects = 0
+	return true
+}
+
+// How long an aircraft's last position can be used as the reference for
+// decoding a single CPR frame
+const localCPRReferenceAge = 5 * time.Minute
 
-	const airdlat0 = float64(6.0)
-	const airdlat1 = float64(360.0) / float64(59.0)
+// A single frame is only unambiguous within half a zone of its reference,
+// about 180 NM airborne and 45 NM on the surface
+const (
+	localCPRAirborneRange = 180 * 1852.0
+	localCPRSurfaceRange  = 45 * 1852.0
+)
 
-	rlatEven := airdlat0 * (float64(j%60) + float64(evenLat)/131072.0)
-	rlatOdd := airdlat1 * (float64(j%59) + float64(oddLat)/131072.0)
-	if rlatEven >= 270 {
-		rlatEven -= 360
+// receiverCPRRange is how far from the receiver a single airborne frame
+// decoded against it can be trusted, 0 if it can't be at all. An aircraft
+// heard at maxRange from the receiver can decode to a wrong position up to a
+// zone, 360 NM, minus maxRange away, so only positions closer than that
+// are unambiguous.
+func receiverCPRRange() float64 {
+	receiverRange := milesInMeters(*maxRange)
+	switch {
+	case receiverRange <= localCPRAirborneRange:
+		return receiverRange
+	case receiverRange < 2*localCPRAirborneRange:
+		return 2*localCPRAirborneRange - receiverRange
 	}
-	if rlatOdd >= 270 {
-		rlatOdd -= 360
-	}
-
-	nlEven := cprNLFunction(rlatEven)
-	nlOdd := cprNLFunction(rlatOdd)
+	return 0
+}
 
-	if nlEven != nlOdd {
+func setPositions(aircraft *aircraftData, cpr modes.CPR, surface bool) (latitude float64, longitude float64) {
+	if (cpr.Lat == math.MaxUint32) || (cpr.Lon == math.MaxUint32) {
 		return math.MaxFloat64, math.MaxFloat64
 	}
 
-	var ni int16
+	rawLatitude, rawLongitude := cpr.Lat, cpr.Lon
+	tFlag := cpr.TFlag
+	isOddFrame := cpr.Odd
 
-	if lastOdd {
-		ni = int16(nlOdd) - 1
-	} else {
-		ni = int16(nlEven) - 1
-	}
-	if ni < 1 {
-		ni = 1
+	if surface != aircraft.onGround {
+		// Airborne and surface frames can't be paired with each other
+		aircraft.eRawLat, aircraft.eRawLon = math.MaxUint32, math.MaxUint32
+		aircraft.oRawLat, aircraft.oRawLon = math.MaxUint32, math.MaxUint32
+		aircraft.onGround = surface
 	}
 
-	var outLat float64
-	var outLon float64
-	if tFlag {
-		m := int16(math.Floor((float64(int32(evenLon*uint32(cprNLFunction(rlatOdd)-1))-
-			int32(oddLon*uint32(cprNLFunction(rlatOdd)))) / 131072.0) + 0.5))
-		outLon = cprDlonFunction(rlatOdd, tFlag, false) * (float64(m%ni) + float64(oddLon)/131072.0)
-		outLat = rlatOdd
-
+	now := time.Now()
+	if isOddFrame {
+		aircraft.oRawLat = rawLatitude
+		aircraft.oRawLon = rawLongitude
+		aircraft.oRawTime = now
 	} else {
-		m := int16(math.Floor((float64(int32(evenLon*uint32(cprNLFunction(rlatEven)-1))-
-			int32(oddLon*uint32(cprNLFunction(rlatEven)))) / 131072.0) + 0.5))
-		outLon = cprDlonFunction(rlatEven, tFlag, false) * (float64(m%ni) + float64(evenLon)/131072.0)
-		outLat = rlatEven
+		aircraft.eRawLat = rawLatitude
+		aircraft.eRawLon = rawLongitude
+		aircraft.eRawTime = now
 	}
 
-	outLon -= math.Floor((outLon+180.0)/360.0) * 360.0
-
-	return outLat, outLon
-}
-
-func decodeCallsign(message *[]byte) string {
-	chars1 := uint((*message)[5])<<16 + uint((*message)[6])<<8 + uint((*message)[7])
-	chars2 := uint((*message)[8])<<16 + uint((*message)[9])<<8 + uint((*message)[10])
-
-	var fltByte [8]byte
-
-	if chars1 != 0 && chars2 != 0 {
-		// Flush the buffered raw bits into the representative 8 char string
-
-		fltByte[3] = aisCharset[chars1&0x3F]
-		chars1 >>= 6
-
-		fltByte[2] = aisCharset[chars1&0x3F]
-		chars1 >>= 6
-
-		fltByte[1] = aisCharset[chars1&0x3F]
-		chars1 >>= 6
-
-		fltByte[0] = aisCharset[chars1&0x3F]
-
-		fltByte[7] = aisCharset[chars2&0x3F]
-		chars2 >>= 6
-
-		fltByte[6] = aisCharset[chars2&0x3F]
-		chars2 >>= 6
-
-		fltByte[5] = aisCharset[chars2&0x3F]
-		chars2 >>= 6
-
-		fltByte[4] = aisCharset[chars2&0x3F]
-
-		return string(fltByte[:8])
+	// Global decoding bootstraps from a pair of frames, as long as the
+	// aircraft can't have moved into another zone between them
+	pairAge := aircraft.eRawTime.Sub(aircraft.oRawTime)
+	if pairAge < 0 {
+		pairAge = -pairAge
 	}
 
-	return ""
-}
-
-func decodeAirbornePosition(message *[]byte, msgType uint) (rawLatitude uint32, rawLongitude uint32, altitude int32) {
-	ac12Data := (uint((*message)[5]) << 4) + (uint((*message)[6])>>4)&0x0FFF
-
-	rawLatitude = uint32((*message)[6])&3<<15 + uint32((*message)[7])<<7 +
-		uint32((*message)[8])>>1
-	rawLongitude = uint32((*message)[8])&1<<16 + uint32((*message)[9])<<8 +
-		uint32((*message)[10])
-
-	if msgType != 20 && msgType != 21 && msgType != 22 {
-		altitude = decodeAC12Field(ac12Data)
+	if pairAge > cprPairWindow {
+		latitude, longitude = math.MaxFloat64, math.MaxFloat64
+	} else if surface {
+		latitude, longitude = modes.DecodeSurfaceCPR(aircraft.eRawLat, aircraft.eRawLon, aircraft.oRawLat, aircraft.oRawLon,
+			isOddFrame, *baseLat, *baseLon)
+	} else {
+		latitude, longitude = modes.DecodeAirborneCPR(aircraft.eRawLat, aircraft.eRawLon, aircraft.oRawLat, aircraft.oRawLon,
+			isOddFrame, tFlag)
+	}
+	if latitude != math.MaxFloat64 && longitude != math.MaxFloat64 {
+		return latitude, longitude
 	}
 
-	return rawLatitude, rawLongitude, altitude
-}
+	// Otherwise a single frame can be decoded relative to somewhere nearby
+	if aircraft.latitude != math.MaxFloat64 && aircraft.longitude != math.MaxFloat64 &&
+		time.Since(aircraft.lastPos) < localCPRReferenceAge {
+		return modes.DecodeLocalCPR(rawLatitude, rawLongitude, isOddFrame, surface, aircraft.latitude, aircraft.longitude)
+	}
 
-func setPositions(message *[]byte, aircraft *aircraftData, rawLatitude uint32, rawLongitude uint32) (latitude float64, longitude float64) {
-	if (rawLatitude != math.MaxUint32) && (rawLongitude != math.MaxUint32) {
-		tFlag := (byte((*message)[6]) & 8) == 8
-		isOddFrame := (byte((*message)[6]) & 4) == 4
-
-		if isOddFrame && aircraft.eRawLat != math.MaxUint32 && aircraft.eRawLon != math.MaxUint32 {
-			// Odd frame and we have previous even frame data
-			latitude, longitude = parseRawLatLon(aircraft.eRawLat, aircraft.eRawLon, rawLatitude, rawLongitude, isOddFrame, tFlag)
-			// Reset our buffer
-			aircraft.eRawLat = math.MaxUint32
-			aircraft.eRawLon = math.MaxUint32
-		} else if !isOddFrame && aircraft.oRawLat != math.MaxUint32 && aircraft.oRawLon != math.MaxUint32 {
-			// Even frame and we have previous odd frame data
-			latitude, longitude = parseRawLatLon(rawLatitude, rawLongitude, aircraft.oRawLat, aircraft.oRawLon, isOddFrame, tFlag)
-			// Reset buffer
-			aircraft.oRawLat = math.MaxUint32
-			aircraft.oRawLon = math.MaxUint32
-		} else if isOddFrame {
-			aircraft.oRawLat = rawLatitude
-			aircraft.oRawLon = rawLongitude
-		} else {
-			aircraft.eRawLat = rawLatitude
-			aircraft.eRawLon = rawLongitude
+	// Surface frames are always decoded against the receiver, aircraft on the
+	// ground aren't heard from far enough away to be ambiguous
+	limit := localCPRSurfaceRange
+	if !surface {
+		if limit = receiverCPRRange(); limit == 0 {
+			return math.MaxFloat64, math.MaxFloat64
 		}
 	}
 
+	latitude, longitude = modes.DecodeLocalCPR(rawLatitude, rawLongitude, isOddFrame, surface, *baseLat, *baseLon)
+	if latitude == math.MaxFloat64 || longitude == math.MaxFloat64 {
+		return latitude, longitude
+	}
+	if GreatCircle(latitude, longitude, *baseLat, *baseLon) > limit {
+		return math.MaxFloat64, math.MaxFloat64
+	}
+
 	return latitude, longitude
 }
@@ -2,10 +2,14 @@ package main
 
 import (
 	"encoding/binary"
+	"errors"
 	"math"
 	"reflect"
+	"sync"
 	"testing"
 	"time"
+
+	"github.com/jsmithedin/overmyhouse/pkg/modes"
 )
 
 func Test_ParseTime(t *testing.T) {
@@ -30,36 +34,16 @@ func createTimestampBytes(value uint64) []byte {
 	return timestampBytes
 }
 
-func Test_ParseRawLatLon(t *testing.T) {
-	tests := []struct {
-		evenLat uint32
-		evenLon uint32
-		oddLat  uint32
-		oddLon  uint32
-		lastOdd bool
-		tFlag   bool
-		latWant float64
-		lonWant float64
-	}{
-		{evenLat: 92095, evenLon: 39846, oddLat: 88385, oddLon: 125818, lastOdd: false, tFlag: false, latWant: 10.215774536132812, lonWant: 123.88881877317269},
-		{evenLat: 92095, evenLon: 39846, oddLat: 88385, oddLon: 125818, lastOdd: false, tFlag: true, latWant: 10.21621445478019, lonWant: 123.8891285863416},
-		{evenLat: 92095, evenLon: 39846, oddLat: 88385, oddLon: 125818, lastOdd: true, tFlag: false, latWant: 10.215774536132812, lonWant: 123.88881877317269},
-		{evenLat: 92095, evenLon: 39846, oddLat: 88385, oddLon: 125818, lastOdd: true, tFlag: true, latWant: 10.21621445478019, lonWant: 123.8891285863416},
-		{evenLat: 92095, evenLon: 39846, oddLat: 88385, oddLon: math.MaxUint32, lastOdd: false, tFlag: false, latWant: math.MaxFloat64, lonWant: math.MaxFloat64},
-	}
-
-	for _, tc := range tests {
-		latGot, lonGot := parseRawLatLon(tc.evenLat, tc.evenLon, tc.oddLat, tc.oddLon, tc.lastOdd, tc.tFlag)
-		if !reflect.DeepEqual(tc.latWant, latGot) {
-			t.Fatalf("expected: %v, got: %v", tc.latWant, latGot)
-		}
-		if !reflect.DeepEqual(tc.lonWant, lonGot) {
-			t.Fatalf("expected: %v, got: %v", tc.lonWant, lonGot)
-		}
+// decodeInto decodes a frame and applies it to an aircraft as parseModeS would
+func decodeInto(t *testing.T, message []byte, aircraft *aircraftData) {
+	decoded, err := modes.Decode(message)
+	if err != nil {
+		t.Fatalf("%v: %v", message, err)
 	}
+	applyMessage(decoded, aircraft)
 }
 
-func Test_decodeExtendedSquitter(t *testing.T) {
+func Test_applyExtendedSquitter(t *testing.T) {
 	testAircraft := aircraftData{}
 
 	tests := []struct {
@@ -70,14 +54,16 @@ func Test_decodeExtendedSquitter(t *testing.T) {
 		longitude float64
 	}{
 		{message: []byte{141, 64, 12, 74, 153, 68, 2, 22, 232, 72, 11, 144, 151, 165}, callsign: "", altitude: 0, latitude: 0, longitude: 0},
-		{message: []byte{141, 64, 86, 11, 88, 37, 196, 163, 243, 90, 151, 218, 105, 13}, callsign: "", altitude: 6500, latitude: 0, longitude: 0},
-		{message: []byte{141, 64, 115, 119, 33, 52, 66, 112, 226, 8, 32, 34, 235, 246}, callsign: "MDI08   ", altitude: 6500, latitude: 0, longitude: 0},
-		{message: []byte{141, 64, 115, 119, 232, 52, 66, 112, 226, 8, 32, 34, 235, 246}, callsign: "MDI08   ", altitude: 6500, latitude: 0, longitude: 0},
-		{message: []byte{141, 64, 115, 119, 40, 52, 66, 112, 226, 8, 32, 34, 235, 246}, callsign: "MDI08   ", altitude: 6500, latitude: 219.6614227294922, longitude: 5.712890625},
+		// Single odd frame decoded relative to the receiver
+		{message: []byte{141, 64, 86, 11, 88, 37, 196, 163, 243, 90, 151, 218, 105, 13}, callsign: "", altitude: 6500, latitude: 55.892152947894594, longitude: -3.634500503540039},
+		{message: []byte{141, 64, 115, 119, 33, 52, 66, 112, 226, 8, 32, 34, 235, 246}, callsign: "MDI08   ", altitude: 6500, latitude: 55.892152947894594, longitude: -3.634500503540039},
+		{message: []byte{141, 64, 115, 119, 232, 52, 66, 112, 226, 8, 32, 194, 67, 9}, callsign: "MDI08   ", altitude: 6500, latitude: 55.892152947894594, longitude: -3.634500503540039},
+		// Surface position decodes to 50 miles from the last one so isn't believed
+		{message: []byte{141, 64, 115, 119, 40, 52, 66, 112, 226, 8, 32, 29, 98, 148}, callsign: "MDI08   ", altitude: 6500, latitude: 55.892152947894594, longitude: -3.634500503540039},
 	}
 
 	for _, tc := range tests {
-		decodeExtendedSquitter(tc.message, &testAircraft)
+		decodeInto(t, tc.message, &testAircraft)
 		if !reflect.DeepEqual(testAircraft.callsign, tc.callsign) {
 			t.Fatalf("expected: %v, got: :%v:", tc.callsign, testAircraft.callsign)
 		}
@@ -94,35 +80,81 @@ func Test_decodeExtendedSquitter(t *testing.T) {
 }
 
 func Test_setPositions(t *testing.T) {
-	testAircraft := aircraftData{}
-	testAircrafteLatLon := aircraftData{eRawLat: math.MaxUint32, eRawLon: math.MaxUint32}
-	testAircraftoLatLon := aircraftData{oRawLat: math.MaxUint32, oRawLon: math.MaxUint32}
+	unknown := newAircraftData(0x40621D, false)
+	recent := newAircraftData(0x40621D, false)
+	recent.latitude, recent.longitude, recent.lastPos = 52.258, 3.918, time.Now()
+	stale := newAircraftData(0x40621D, false)
+	stale.latitude, stale.longitude, stale.lastPos = 52.258, 3.918, time.Now().Add(-10*time.Minute)
+	paired := newAircraftData(0x40621D, false)
+	paired.oRawLat, paired.oRawLon, paired.oRawTime = 74158, 50194, time.Now()
+	stalePair := newAircraftData(0x40621D, false)
+	stalePair.oRawLat, stalePair.oRawLon, stalePair.oRawTime = 74158, 50194, time.Now().Add(-20*time.Second)
 
 	tests := []struct {
-		message      []byte
+		name         string
 		testAircraft aircraftData
-		rawLatitude  uint32
-		rawLongitude uint32
+		cpr          modes.CPR
 		latitude     float64
 		longitude    float64
 	}{
-		{message: []byte{141, 64, 115, 119, 33, 52, 66, 112, 226, 8, 32, 34, 235, 246}, testAircraft: testAircraft, rawLatitude: 0, rawLongitude: 0, latitude: 0, longitude: 0},
-		{message: []byte{141, 64, 115, 119, 33, 52, 60, 112, 226, 8, 32, 34, 235, 246}, testAircraft: testAircraft, rawLatitude: 0, rawLongitude: 0, latitude: 0, longitude: 0},
-		{message: []byte{141, 64, 115, 119, 33, 52, 66, 112, 226, 8, 32, 34, 235, 246}, testAircraft: testAircrafteLatLon, rawLatitude: 0, rawLongitude: 0, latitude: 0, longitude: 0},
-		{message: []byte{141, 64, 115, 119, 33, 52, 60, 112, 226, 8, 32, 34, 235, 246}, testAircraft: testAircrafteLatLon, rawLatitude: 0, rawLongitude: 0, latitude: 0, longitude: 0},
-		{message: []byte{141, 64, 115, 119, 33, 52, 66, 112, 226, 8, 32, 34, 235, 246}, testAircraft: testAircraftoLatLon, rawLatitude: 0, rawLongitude: 0, latitude: 0, longitude: 0},
-		{message: []byte{141, 64, 115, 119, 33, 52, 60, 112, 226, 8, 32, 34, 235, 246}, testAircraft: testAircraftoLatLon, rawLatitude: 0, rawLongitude: 0, latitude: 0, longitude: 0},
+		{name: "relative to receiver", testAircraft: unknown, cpr: modes.CPR{Lat: 20985, Lon: 88727, Odd: true}, latitude: 55.892152947894594, longitude: -3.634500503540039},
+		{name: "beyond receiver range", testAircraft: unknown, cpr: modes.CPR{Lat: 93000, Lon: 51372}, latitude: math.MaxFloat64, longitude: math.MaxFloat64},
+		// 200 NM north, which decodes to 160 NM south against the receiver
+		{name: "beyond 180 NM", testAircraft: unknown, cpr: modes.CPR{Lat: 114561, Lon: 95716}, latitude: math.MaxFloat64, longitude: math.MaxFloat64},
+		{name: "relative to last position", testAircraft: recent, cpr: modes.CPR{Lat: 93000, Lon: 51372}, latitude: 52.2572021484375, longitude: 3.91937255859375},
+		{name: "stale last position", testAircraft: stale, cpr: modes.CPR{Lat: 93000, Lon: 51372}, latitude: math.MaxFloat64, longitude: math.MaxFloat64},
+		{name: "global pair", testAircraft: paired, cpr: modes.CPR{Lat: 93000, Lon: 51372}, latitude: 52.2572021484375, longitude: 3.91937255859375},
+		{name: "pair too far apart", testAircraft: stalePair, cpr: modes.CPR{Lat: 93000, Lon: 51372}, latitude: math.MaxFloat64, longitude: math.MaxFloat64},
+		{name: "no raw position", testAircraft: unknown, cpr: modes.CPR{Lat: math.MaxUint32, Lon: math.MaxUint32, Odd: true}, latitude: math.MaxFloat64, longitude: math.MaxFloat64},
 	}
 
 	for _, tc := range tests {
-		latGot, lonGot := setPositions(&tc.message, &tc.testAircraft, tc.rawLatitude, tc.rawLongitude)
-		if !reflect.DeepEqual(latGot, tc.latitude) {
-			t.Fatalf("expected: %v, got: %v", tc.latitude, latGot)
+		latGot, lonGot := setPositions(&tc.testAircraft, tc.cpr, false)
+		if math.Abs(latGot-tc.latitude) > 0.00001 {
+			t.Fatalf("%s: expected: %v, got: %v", tc.name, tc.latitude, latGot)
+		}
+		if math.Abs(lonGot-tc.longitude) > 0.00001 {
+			t.Fatalf("%s: expected: %v, got %v", tc.name, tc.longitude, lonGot)
 		}
-		if !reflect.DeepEqual(lonGot, tc.longitude) {
-			t.Fatalf("expected: %v, got %v", tc.longitude, lonGot)
+	}
+}
+
+func Test_receiverCPRRange(t *testing.T) {
+	defer func(miles float64) { *maxRange = miles }(*maxRange)
+
+	tests := []struct {
+		miles float64
+		want  float64
+	}{
+		{miles: 100, want: milesInMeters(100)},
+		{miles: 300, want: 360*1852 - milesInMeters(300)},
+		{miles: 450, want: 0},
+	}
+
+	for _, tc := range tests {
+		*maxRange = tc.miles
+		if got := receiverCPRRange(); math.Abs(got-tc.want) > 0.001 {
+			t.Fatalf("expected: %v, got: %v", tc.want, got)
 		}
 	}
+
+	// Close enough to the receiver to decode but not with a range too long to trust
+	unknown := newAircraftData(0x40621D, false)
+	cpr := modes.CPR{Lat: 20985, Lon: 88727, Odd: true}
+	if latitude, _ := setPositions(&unknown, cpr, false); latitude != math.MaxFloat64 {
+		t.Fatalf("expected no position, got: %v", latitude)
+	}
+}
+
+func Test_setPositionsKeepsBothHalves(t *testing.T) {
+	testAircraft := newAircraftData(0x40621D, false)
+
+	setPositions(&testAircraft, modes.CPR{Lat: 74158, Lon: 50194, Odd: true}, false)
+	setPositions(&testAircraft, modes.CPR{Lat: 93000, Lon: 51372}, false)
+
+	if testAircraft.eRawLat != 93000 || testAircraft.oRawLat != 74158 {
+		t.Fatalf("expected both halves to be kept, got even %v odd %v", testAircraft.eRawLat, testAircraft.oRawLat)
+	}
 }
 
 func Test_parseModeS(t *testing.T) {
@@ -136,6 +168,8 @@ func Test_parseModeS(t *testing.T) {
 		{message: []byte{141, 64, 15, 154, 153, 20, 254, 133, 161, 36, 130, 240, 148, 109}, isMlat: true, number: 1},
 		{message: []byte{141, 64, 15, 154, 153, 20, 254, 133, 161, 36, 130, 240, 148, 109}, isMlat: true, number: 1},
 		{message: []byte{0, 64, 15, 154, 153, 20, 254, 133, 161, 36, 130, 240, 148, 109}, isMlat: true, number: 1},
+		{message: []byte{141, 64, 15, 155, 153, 20, 254, 133, 161, 36, 130, 240, 148, 109}, isMlat: true, number: 1},
+		{message: []byte{93, 72, 64, 214, 248, 116, 15}, isMlat: false, number: 2},
 	}
 
 	for _, tc := range tests {
@@ -145,3 +179,363 @@ func Test_parseModeS(t *testing.T) {
 		}
 	}
 }
+
+// Run with -race: the halves of a position and a callsign arriving on
+// different connections at once must all be kept
+func Test_parseModeSConcurrently(t *testing.T) {
+	testKnownAircraft := &KnownAircraft{}
+	frames := [][]byte{
+		{141, 64, 98, 29, 88, 195, 130, 214, 144, 200, 172, 40, 99, 167},
+		{141, 64, 98, 29, 88, 195, 134, 67, 92, 196, 18, 105, 42, 214},
+		{141, 64, 98, 29, 33, 52, 66, 112, 226, 8, 32, 17, 102, 34},
+	}
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 50; i++ {
+		for _, frame := range frames {
+			wg.Add(1)
+			go func(frame []byte) {
+				defer wg.Done()
+				if _, err := parseModeS(frame, false, testKnownAircraft); err != nil {
+					t.Error(err)
+				}
+				_ = testKnownAircraft.sortedAircraft()
+			}(frame)
+		}
+	}
+	wg.Wait()
+
+	aircraft, known := testKnownAircraft.getAircraft(0x40621D)
+	if !known {
+		t.Fatalf("expected 40621d to be known")
+	}
+	if aircraft.callsign != "MDI08   " {
+		t.Fatalf("expected: MDI08, got: %q", aircraft.callsign)
+	}
+	if aircraft.eRawLat == math.MaxUint32 || aircraft.oRawLat == math.MaxUint32 {
+		t.Fatalf("expected both halves to be kept, got even %v odd %v", aircraft.eRawLat, aircraft.oRawLat)
+	}
+}
+
+func Test_parseModeSErrors(t *testing.T) {
+	testKnownAircraft := &KnownAircraft{}
+	var stats decodeStats
+
+	tests := []struct {
+		message []byte
+		err     error
+	}{
+		{message: []byte{}, err: modes.ErrLength},
+		{message: []byte{141, 64, 15}, err: modes.ErrLength},
+		{message: []byte{141, 64, 15, 154, 153, 20, 254}, err: modes.ErrLength},
+		{message: []byte{93, 72, 64, 214, 248, 116, 15, 0}, err: modes.ErrLength},
+		{message: []byte{93, 72, 64, 214, 248, 118, 15}, err: modes.ErrCRC},
+		{message: []byte{147, 64, 98, 29, 88, 195, 130, 214, 144, 200, 172, 189, 252, 218}, err: modes.ErrUnsupported},
+		{message: []byte{93, 72, 64, 214, 248, 116, 15}, err: nil},
+	}
+
+	for _, tc := range tests {
+		_, err := parseModeS(tc.message, false, testKnownAircraft)
+		if !errors.Is(err, tc.err) || (err == nil) != (tc.err == nil) {
+			t.Fatalf("expected: %v, got: %v", tc.err, err)
+		}
+		stats.count(err)
+	}
+
+	want := decodeStats{length: 4, crc: 1, unsupported: 1}
+	if stats != want {
+		t.Fatalf("expected: %+v, got: %+v", want, stats)
+	}
+	if testKnownAircraft.getNumberOfKnown() != 1 {
+		t.Fatalf("expected: 1, got: %v", testKnownAircraft.getNumberOfKnown())
+	}
+}
+
+func Test_parseModeSAddressParity(t *testing.T) {
+	testKnownAircraft := &KnownAircraft{}
+
+	tests := []struct {
+		message  []byte
+		altitude int32
+		number   int
+	}{
+		// DF4 before the aircraft has announced itself is dropped
+		{message: []byte{32, 0, 24, 56, 89, 195, 141}, altitude: math.MaxInt32, number: 0},
+		{message: []byte{93, 72, 64, 214, 248, 116, 15}, altitude: math.MaxInt32, number: 1},
+		{message: []byte{32, 0, 24, 56, 89, 195, 141}, altitude: 38000, number: 1},
+		// Same reply overlaid with a different address
+		{message: []byte{32, 0, 24, 56, 89, 195, 140}, altitude: 38000, number: 1},
+	}
+
+	for _, tc := range tests {
+		parseModeS(tc.message, false, testKnownAircraft)
+		if testKnownAircraft.getNumberOfKnown() != tc.number {
+			t.Fatalf("expected: %v, got: %v", tc.number, testKnownAircraft.getNumberOfKnown())
+		}
+
+		aircraft, known := testKnownAircraft.getAircraft(0x4840D6)
+		if known && aircraft.altitude != tc.altitude {
+			t.Fatalf("expected: %v, got: %v", tc.altitude, aircraft.altitude)
+		}
+	}
+}
+
+func Test_applySurfacePosition(t *testing.T) {
+	defer func(lat float64, lon float64) { *baseLat, *baseLon = lat, lon }(*baseLat, *baseLon)
+	*baseLat, *baseLon = 51.990, 4.375
+
+	testAircraft := newAircraftData(0x484175, false)
+
+	decodeInto(t, []byte{140, 72, 65, 117, 58, 138, 53, 50, 63, 174, 189, 172, 112, 45}, &testAircraft)
+	decodeInto(t, []byte{140, 72, 65, 117, 58, 171, 35, 135, 51, 200, 205, 64, 32, 177}, &testAircraft)
+
+	if !testAircraft.onGround {
+		t.Fatalf("expected aircraft to be on the ground")
+	}
+	if math.Abs(testAircraft.latitude-52.32304) > 0.0001 || math.Abs(testAircraft.longitude-4.73047) > 0.0001 {
+		t.Fatalf("expected: 52.32304,4.73047, got: %v,%v", testAircraft.latitude, testAircraft.longitude)
+	}
+
+	decodeInto(t, []byte{140, 72, 65, 117, 58, 154, 21, 50, 55, 174, 240, 242, 117, 190}, &testAircraft)
+	if testAircraft.groundSpeed != 17 {
+		t.Fatalf("expected: 17, got: %v", testAircraft.groundSpeed)
+	}
+	if testAircraft.track != 92.8125 {
+		t.Fatalf("expected: 92.8125, got: %v", testAircraft.track)
+	}
+}
+
+func Test_plausiblePosition(t *testing.T) {
+	now := time.Now()
+
+	tests := []struct {
+		name      string
+		latitude  float64
+		longitude float64
+		lastPos   time.Time
+		speed     float64
+		want      bool
+	}{
+		{name: "first position", latitude: 55.95, longitude: -3.2, want: true},
+		{name: "beyond receiver range", latitude: 40.0, longitude: -3.2, want: false},
+		{name: "short hop", latitude: 55.91, longitude: -3.23, lastPos: now.Add(-time.Second), speed: math.MaxFloat64, want: true},
+		{name: "too far in a second", latitude: 56.5, longitude: -3.23, lastPos: now.Add(-time.Second), speed: math.MaxFloat64, want: false},
+		{name: "far but a while ago", latitude: 56.5, longitude: -3.23, lastPos: now.Add(-5 * time.Minute), speed: math.MaxFloat64, want: true},
+		{name: "faster than reported speed", latitude: 56.0, longitude: -3.23, lastPos: now.Add(-time.Minute), speed: 100, want: false},
+	}
+
+	for _, tc := range tests {
+		testAircraft := newAircraftData(0x40621D, false)
+		if !tc.lastPos.IsZero() {
+			testAircraft.latitude, testAircraft.longitude = *baseLat, *baseLon
+			testAircraft.lastPos = tc.lastPos
+			testAircraft.groundSpeed = tc.speed
+		}
+
+		got := plausiblePosition(&testAircraft, tc.latitude, tc.longitude, now)
+		if got != tc.want {
+			t.Fatalf("%s: expected: %v, got: %v", tc.name, tc.want, got)
+		}
+	}
+}
+
+func Test_acceptPosition(t *testing.T) {
+	now := time.Now()
+	testAircraft := newAircraftData(0x4840D6, false)
+
+	if !acceptPosition(&testAircraft, 55.95, -3.2, now) {
+		t.Fatalf("expected the first position to be accepted")
+	}
+	if testAircraft.latitude != 55.95 || testAircraft.distance == math.MaxFloat64 || !testAircraft.lastPos.Equal(now) ||
+		len(testAircraft.history.since(time.Time{}, now)) != 1 {
+		t.Fatalf("expected the position, its time and a track point, got: %+v", testAircraft)
+	}
+
+	// 100 km in a second
+	if acceptPosition(&testAircraft, 56.85, -3.2, now.Add(time.Second)) {
+		t.Fatalf("expected an impossible jump to be rejected")
+	}
+	if testAircraft.latitude != 55.95 || !testAircraft.lastPos.Equal(now) ||
+		len(testAircraft.history.since(time.Time{}, now)) != 1 {
+		t.Fatalf("expected the aircraft to be left where it was, got: %+v", testAircraft)
+	}
+
+	if acceptPosition(&testAircraft, 55.95, -3.2, now) || testAircraft.posRejects != 1 {
+		t.Fatalf("expected a fix no newer than the last to be ignored, got: %v rejects", testAircraft.posRejects)
+	}
+}
+
+func Test_plausiblePositionResets(t *testing.T) {
+	testAircraft := newAircraftData(0x40621D, false)
+	testAircraft.latitude, testAircraft.longitude = *baseLat, *baseLon
+	testAircraft.lastPos = time.Now()
+
+	for i := 0; i < maxPositionRejects; i++ {
+		if plausiblePosition(&testAircraft, 56.5, -3.23, time.Now()) {
+			t.Fatalf("expected position to be rejected")
+		}
+	}
+
+	if testAircraft.latitude != math.MaxFloat64 {
+		t.Fatalf("expected last position to be dropped after %d rejects", maxPositionRejects)
+	}
+	if !plausiblePosition(&testAircraft, 56.5, -3.23, time.Now()) {
+		t.Fatalf("expected position to be accepted once the old one was dropped")
+	}
+}
+
+func Test_parseModeSIdentity(t *testing.T) {
+	testKnownAircraft := &KnownAircraft{}
+
+	tests := []struct {
+		message   []byte
+		squawk    uint16
+		emergency uint8
+	}{
+		{message: []byte{93, 72, 64, 214, 248, 116, 15}, squawk: math.MaxUint16, emergency: 0},
+		// DF5 squawking 7700
+		{message: []byte{40, 0, 10, 170, 2, 228, 31}, squawk: 0x7700, emergency: 0},
+		// Extended squitter type 28 general emergency
+		{message: []byte{141, 72, 64, 214, 225, 42, 170, 0, 0, 0, 0, 60, 245, 206}, squawk: 0x7700, emergency: 1},
+	}
+
+	for _, tc := range tests {
+		parseModeS(tc.message, false, testKnownAircraft)
+
+		aircraft, known := testKnownAircraft.getAircraft(0x4840D6)
+		if !known {
+			t.Fatalf("expected aircraft to be known")
+		}
+		if aircraft.squawk != tc.squawk {
+			t.Fatalf("expected: %04x, got: %04x", tc.squawk, aircraft.squawk)
+		}
+		if aircraft.emergency != tc.emergency {
+			t.Fatalf("expected: %v, got: %v", tc.emergency, aircraft.emergency)
+		}
+	}
+}
+
+func Test_parseModeSDF18(t *testing.T) {
+	tests := []struct {
+		message []byte
+		isMlat  bool
+		addr    uint32
+		source  modes.Source
+		addrStr string
+	}{
+		{message: []byte{141, 64, 98, 29, 88, 195, 130, 214, 144, 200, 172, 40, 99, 167}, addr: 0x40621D, source: modes.SourceADSB, addrStr: "40621d"},
+		{message: []byte{141, 64, 98, 29, 88, 195, 130, 214, 144, 200, 172, 40, 99, 167}, isMlat: true, addr: 0x40621D, source: modes.SourceMLAT, addrStr: "40621d"},
+		{message: []byte{144, 64, 98, 29, 88, 195, 130, 214, 144, 200, 172, 85, 111, 82}, addr: 0x40621D, source: modes.SourceADSB, addrStr: "40621d"},
+		{message: []byte{145, 64, 98, 29, 88, 195, 130, 214, 144, 200, 172, 13, 30, 42}, addr: anonAddrFlag | 0x40621D, source: modes.SourceADSB, addrStr: "#40621d"},
+		{message: []byte{146, 64, 98, 29, 88, 195, 130, 214, 144, 200, 172, 229, 141, 162}, addr: 0x40621D, source: modes.SourceTISB, addrStr: "40621d"},
+		{message: []byte{146, 64, 98, 29, 89, 195, 130, 214, 144, 200, 172, 57, 247, 85}, addr: nonICAOAddrFlag | 0x40621D, source: modes.SourceTISB, addrStr: "~40621d"},
+		{message: []byte{149, 64, 98, 29, 88, 195, 130, 214, 144, 200, 172, 147, 47, 195}, addr: nonICAOAddrFlag | 0x40621D, source: modes.SourceTISB, addrStr: "~40621d"},
+		{message: []byte{150, 64, 98, 29, 88, 195, 130, 214, 144, 200, 172, 123, 188, 75}, addr: 0x40621D, source: modes.SourceADSR, addrStr: "40621d"},
+		{message: []byte{150, 64, 98, 29, 89, 195, 130, 214, 144, 200, 172, 167, 198, 188}, addr: nonICAOAddrFlag | 0x40621D, source: modes.SourceADSR, addrStr: "~40621d"},
+	}
+
+	for _, tc := range tests {
+		testKnownAircraft := &KnownAircraft{}
+		parseModeS(tc.message, tc.isMlat, testKnownAircraft)
+
+		aircraft, known := testKnownAircraft.getAircraft(tc.addr)
+		if !known || testKnownAircraft.getNumberOfKnown() != 1 {
+			t.Fatalf("expected %06x to be the only aircraft known", tc.addr)
+		}
+		if aircraft.source != tc.source {
+			t.Fatalf("expected: %v, got: %v", tc.source, aircraft.source)
+		}
+		if aircraft.addrString() != tc.addrStr {
+			t.Fatalf("expected: %v, got: %v", tc.addrStr, aircraft.addrString())
+		}
+	}
+
+	// Coarse TIS-B has a layout of its own and is dropped
+	testKnownAircraft := &KnownAircraft{}
+	parseModeS([]byte{147, 64, 98, 29, 88, 195, 130, 214, 144, 200, 172, 189, 252, 218}, false, testKnownAircraft)
+	if testKnownAircraft.getNumberOfKnown() != 0 {
+		t.Fatalf("expected: 0, got: %v", testKnownAircraft.getNumberOfKnown())
+	}
+}
+
+// benchmarkMessages makes an identification, a pair of positions, a velocity
+// and altitude and identity replies for each of a number of aircraft,
+// interleaved as a receiver would see them
+func benchmarkMessages(aircraft int) []modes.Message {
+	const kinds = 6
+	messages := make([]modes.Message, 0, aircraft*kinds)
+	for i := 0; i < aircraft; i++ {
+		header := modes.Header{DF: 17, Address: uint32(0x400000 + i), AddressType: modes.AddressICAO, Source: modes.SourceADSB}
+		altitudeReply := modes.Header{DF: 4, Address: header.Address, AddressType: modes.AddressParity, Source: modes.SourceModeS}
+		identityReply := modes.Header{DF: 5, Address: header.Address, AddressType: modes.AddressParity, Source: modes.SourceModeS}
+		messages = append(messages,
+			&modes.Identification{Header: header, Callsign: "MDI08   ", Category: 0xA3},
+			&modes.AirbornePosition{Header: header,
+				CPR: modes.CPR{Lat: 93000, Lon: 51372}, Altitude: 38000},
+			&modes.AirbornePosition{Header: header,
+				CPR: modes.CPR{Lat: 74158, Lon: 50194, Odd: true}, Altitude: 38000},
+			&modes.Velocity{Header: header,
+				GroundSpeed: 450, Track: 270, Airspeed: math.MaxInt32, Heading: math.MaxFloat64,
+				VertRate: 0, GNSSBaroDiff: math.MaxInt32},
+			&modes.AltitudeReply{Header: altitudeReply, Altitude: 38000},
+			&modes.IdentityReply{Header: identityReply, Squawk: 0x1234})
+	}
+
+	// Spread each aircraft's messages out
+	shuffled := make([]modes.Message, 0, len(messages))
+	for kind := 0; kind < kinds; kind++ {
+		for i := kind; i < len(messages); i += kinds {
+			shuffled = append(shuffled, messages[i])
+		}
+	}
+	return shuffled
+}
+
+// BenchmarkTrackMessage tracks messages from 1000 aircraft on one connection
+// while the display reads the store twice a second, and reports how many
+// messages a second that comes to. A busy receiver with several feeders sees
+// around 10k.
+func BenchmarkTrackMessage(b *testing.B) {
+	messages := benchmarkMessages(1000)
+	testKnownAircraft := &KnownAircraft{}
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		ticker := time.NewTicker(500 * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				_ = testKnownAircraft.sortedAircraft()
+			}
+		}
+	}()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	start := time.Now()
+	for i := 0; i < b.N; i++ {
+		trackMessage(messages[i%len(messages)], false, testKnownAircraft)
+	}
+	b.ReportMetric(float64(b.N)/time.Since(start).Seconds(), "msgs/s")
+}
+
+// BenchmarkTrackMessageParallel is BenchmarkTrackMessage with a connection
+// per CPU
+func BenchmarkTrackMessageParallel(b *testing.B) {
+	messages := benchmarkMessages(1000)
+	testKnownAircraft := &KnownAircraft{}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	start := time.Now()
+	b.RunParallel(func(pb *testing.PB) {
+		for i := 0; pb.Next(); i++ {
+			trackMessage(messages[i%len(messages)], false, testKnownAircraft)
+		}
+	})
+	b.ReportMetric(float64(b.N)/time.Since(start).Seconds(), "msgs/s")
+}
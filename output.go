@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"log"
 	"math"
+	"strings"
 	"time"
 )
 
@@ -16,19 +17,26 @@ func durationSecondsElapsed(since time.Duration) string {
 	}
 }
 
-func printStats(knownAircraft *KnownAircraft, tweetedAircraft *TweetedAircraft) {
+func printStats(knownAircraft *KnownAircraft, tweetedAircraft *TweetedAircraft, sources []*feedSource) {
 	t := time.Now()
 	numberOfKnownAircraft := knownAircraft.getNumberOfKnown()
 	numberOfTweetedAircraft := tweetedAircraft.getNumberOfTweeted()
 
-	fmt.Printf("%d-%02d-%02dT%02d:%02d:%02d-00:00 Known: %d\tTweeted: %d\n", t.Year(), t.Month(), t.Day(),
-		t.Hour(), t.Minute(), t.Second(), numberOfKnownAircraft, numberOfTweetedAircraft)
+	crcStats := modeSDecoder.Stats()
+
+	fmt.Printf("%d-%02d-%02dT%02d:%02d:%02d-00:00 Known: %d\tTweeted: %d\tCRC good: %d\tcorrected: %d\trejected: %d\n",
+		t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), numberOfKnownAircraft, numberOfTweetedAircraft,
+		crcStats.Good, crcStats.Corrected, crcStats.Rejected)
+
+	for _, source := range sources {
+		fmt.Printf("\t%s\n", source.report(t))
+	}
 }
 
 func printOverhead(knownAircraft *KnownAircraft, tweetedAircraft *TweetedAircraft, radius *int) {
 	sortedAircraft := knownAircraft.sortedAircraft()
 
-	for icao, aircraft := range sortedAircraft {
+	for _, aircraft := range sortedAircraft {
 		stale := (time.Since(aircraft.lastPos) > time.Duration((10)*time.Second))
 		extraStale := (time.Since(aircraft.lastPos) > (time.Duration(20) * time.Second))
 
@@ -40,12 +48,12 @@ func printOverhead(knownAircraft *KnownAircraft, tweetedAircraft *TweetedAircraf
 			sLatLon := fmt.Sprintf("%f,%f", aircraft.latitude, aircraft.longitude)
 			sAlt := fmt.Sprintf("%d", aircraft.altitude)
 
-			distance := GreatCircle(aircraft.latitude, aircraft.longitude,
-				*baseLat, *baseLon)
+			distance := aircraft.distance
 
 			tPos := time.Since(aircraft.lastPos)
 
-			if !stale && !extraStale && metersInMiles(distance) < float64(*radius) {
+			if !stale && !extraStale && metersInMiles(distance) < float64(*radius) &&
+				aircraft.categoryAllowed(*categories) {
 				if !tweetedAircraft.alreadyTweeted(aircraft.callsign) {
 					log.Printf("%06x\t%8s\t%s%s\t%3.2f\t%s\n",
 						aircraft.icaoAddr, aircraft.callsign,
@@ -53,8 +61,15 @@ func printOverhead(knownAircraft *KnownAircraft, tweetedAircraft *TweetedAircraf
 						durationSecondsElapsed(tPos))
 
 					if len(aircraft.callsign) > 0 {
-						msg := fmt.Sprintf("https://flightaware.com/live/flight/%8s %8s flew %3.2f miles from my house at %d ft!",
-							aircraft.callsign, aircraft.callsign, metersInMiles(distance), aircraft.altitude)
+						var kind string
+						if category := aircraft.describeCategory(); category != "" {
+							kind = fmt.Sprintf(", %s,", category)
+						}
+
+						callsign := strings.TrimSpace(aircraft.callsign)
+						msg := fmt.Sprintf("https://flightaware.com/live/flight/%s %s%s flew %3.2f miles from my house at %d ft%s!",
+							callsign, callsign, kind, metersInMiles(distance), aircraft.altitude,
+							describeMotion(aircraft))
 
 						sendNotification(msg)
 
@@ -63,15 +78,60 @@ func printOverhead(knownAircraft *KnownAircraft, tweetedAircraft *TweetedAircraf
 				}
 			}
 			if extraStale {
-				knownAircraft.removeAircraft(uint32(icao))
+				knownAircraft.removeAircraftIf(aircraft.icaoAddr, func(latest *aircraftData) bool {
+					return time.Since(latest.lastPos) > 20*time.Second
+				})
 			}
 		}
 	}
 }
 
+// printEmergencies alerts on any aircraft declaring an emergency, wherever it
+// is. Alerts are keyed on the aircraft and the reason so a change of code
+// alerts again. Mode A/C replies have no parity to catch a corrupt code, so
+// aircraft only heard that way never alert.
+func printEmergencies(knownAircraft *KnownAircraft, alertedAircraft *TweetedAircraft) {
+	sortedAircraft := knownAircraft.sortedAircraft()
+
+	for _, aircraft := range sortedAircraft {
+		if aircraft.icaoAddr&modeACAddrFlag > 0 {
+			continue
+		}
+		reason := aircraft.emergencyReason()
+		if reason == "" {
+			continue
+		}
+
+		key := fmt.Sprintf("%s %s", aircraft.addrString(), reason)
+		if alertedAircraft.alreadyTweeted(key) {
+			continue
+		}
+
+		log.Printf("%s\t%8s\t%s\n", aircraft.addrString(), aircraft.callsign, reason)
+
+		var msg string
+		if len(aircraft.callsign) > 0 {
+			msg = fmt.Sprintf("https://flightaware.com/live/flight/%s %s is %s", strings.TrimSpace(aircraft.callsign),
+				strings.TrimSpace(aircraft.callsign), reason)
+		} else {
+			msg = fmt.Sprintf("Aircraft %s is %s", aircraft.addrString(), reason)
+		}
+		if aircraft.altitude != math.MaxInt32 {
+			msg += fmt.Sprintf(" at %d ft", aircraft.altitude)
+		}
+		if aircraft.latitude != math.MaxFloat64 && aircraft.longitude != math.MaxFloat64 {
+			msg += fmt.Sprintf(" %3.2f miles from my house", metersInMiles(aircraft.distance))
+		}
+
+		sendNotification(msg + "!")
+
+		alertedAircraft.addAircraft(key)
+	}
+}
+
 func printAircraftTable(knownAircraft *KnownAircraft) {
 	fmt.Print("\x1b[H\x1b[2J")
-	fmt.Println("ICAO \tCallsign\tLocation\t\tAlt\tDistance   Time")
+	fmt.Println("ICAO \tCallsign\tCat\tSrc\tSqwk\tLocation\t\tAlt\tSpd\tHdg\tV/S\tBrg\tDistance   Time")
 
 	sortedAircraft := knownAircraft.sortedAircraft()
 
@@ -83,7 +143,9 @@ func printAircraftTable(knownAircraft *KnownAircraft) {
 			aircraft.longitude != math.MaxFloat64)
 		aircraftHasAltitude := aircraft.altitude != math.MaxInt32
 
-		if aircraft.callsign != "" || aircraftHasLocation || aircraftHasAltitude {
+		aircraftHasSquawk := aircraft.squawk != math.MaxUint16
+
+		if aircraft.callsign != "" || aircraftHasLocation || aircraftHasAltitude || aircraftHasSquawk {
 			var sLatLon string
 			var sAlt string
 
@@ -98,8 +160,24 @@ func printAircraftTable(knownAircraft *KnownAircraft) {
 				sAlt = "-----"
 			}
 
-			distance := GreatCircle(aircraft.latitude, aircraft.longitude,
-				*baseLat, *baseLon)
+			sSpd, sHdg, sVS := "---", "---", "-----"
+			if aircraft.groundSpeed != math.MaxFloat64 {
+				sSpd = fmt.Sprintf("%.0f", aircraft.groundSpeed)
+			} else if aircraft.airspeed != math.MaxInt32 {
+				sSpd = fmt.Sprintf("%d", aircraft.airspeed)
+			}
+			if direction := aircraft.direction(); direction != math.MaxFloat64 {
+				sHdg = fmt.Sprintf("%03.0f", direction)
+			}
+			if aircraft.vertRate != math.MaxInt32 {
+				sVS = fmt.Sprintf("%d", aircraft.vertRate)
+			}
+
+			sBrg, sDist := "---", "-----"
+			if aircraftHasLocation {
+				sBrg = fmt.Sprintf("%03.0f", aircraft.bearing)
+				sDist = fmt.Sprintf("%3.2f", metersInMiles(aircraft.distance))
+			}
 
 			isMlat := ""
 			if aircraft.mlat {
@@ -109,21 +187,55 @@ func printAircraftTable(knownAircraft *KnownAircraft) {
 			tPos := time.Since(aircraft.lastPos)
 
 			if !stale && !extraStale {
-				fmt.Printf("%06x\t%8s\t%s%s\t%s\t%3.2f\t%s\n",
-					aircraft.icaoAddr, aircraft.callsign,
-					sLatLon, isMlat, sAlt, metersInMiles(distance),
+				fmt.Printf("%s\t%8s\t%s\t%s\t%s\t%s%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+					aircraft.addrString(), aircraft.callsign, aircraft.categoryString(), aircraft.source, aircraft.squawkString(),
+					sLatLon, isMlat, sAlt, sSpd, sHdg, sVS, sBrg, sDist,
 					durationSecondsElapsed(tPos))
 			} else if stale && !extraStale {
-				fmt.Printf("%06x\t%8s\t%s%s?\t%s\t%3.2f?\t%s\n",
-					aircraft.icaoAddr, aircraft.callsign,
-					sLatLon, isMlat, sAlt, metersInMiles(distance),
+				fmt.Printf("%s\t%8s\t%s\t%s\t%s\t%s%s?\t%s\t%s\t%s\t%s\t%s\t%s?\t%s\n",
+					aircraft.addrString(), aircraft.callsign, aircraft.categoryString(), aircraft.source, aircraft.squawkString(),
+					sLatLon, isMlat, sAlt, sSpd, sHdg, sVS, sBrg, sDist,
 					durationSecondsElapsed(tPos))
 			} else {
-				fmt.Printf("%06x\t%8s\t%s%s?\t%s\t%3.2f?\t%s…\n",
-					aircraft.icaoAddr, aircraft.callsign,
-					sLatLon, isMlat, sAlt, metersInMiles(distance),
+				fmt.Printf("%s\t%8s\t%s\t%s\t%s\t%s%s?\t%s\t%s\t%s\t%s\t%s\t%s?\t%s…\n",
+					aircraft.addrString(), aircraft.callsign, aircraft.categoryString(), aircraft.source, aircraft.squawkString(),
+					sLatLon, isMlat, sAlt, sSpd, sHdg, sVS, sBrg, sDist,
 					durationSecondsElapsed(tPos))
 			}
 		}
 	}
 }
+
+// Vertical rates are reported in 64 fpm steps, ignore the odd step either way
+const levelFlightRate = 100
+
+// describeMotion gives the climb rate and direction of an aircraft as a
+// clause to tack on to a notification, e.g. " climbing at 1,800 fpm heading 270°"
+func describeMotion(aircraft *aircraftData) string {
+	var motion string
+
+	if aircraft.vertRate != math.MaxInt32 {
+		switch {
+		case aircraft.vertRate >= levelFlightRate:
+			motion = fmt.Sprintf(" climbing at %s fpm", formatThousands(aircraft.vertRate))
+		case aircraft.vertRate <= -levelFlightRate:
+			motion = fmt.Sprintf(" descending at %s fpm", formatThousands(-aircraft.vertRate))
+		default:
+			motion = " level"
+		}
+	}
+
+	if direction := aircraft.direction(); direction != math.MaxFloat64 {
+		motion += fmt.Sprintf(" heading %03.0f°", direction)
+	}
+
+	return motion
+}
+
+func formatThousands(n int32) string {
+	s := fmt.Sprintf("%d", n)
+	for i := len(s) - 3; i > 0 && s[i-1] != '-'; i -= 3 {
+		s = s[:i] + "," + s[i:]
+	}
+	return s
+}
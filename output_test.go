@@ -1,6 +1,11 @@
 package main
 
 import (
+	"encoding/json"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/http/httptest"
 	"reflect"
 	"testing"
 	"time"
@@ -22,3 +27,123 @@ func Test_durationSecondsElapse(t *testing.T) {
 		}
 	}
 }
+
+func Test_describeMotion(t *testing.T) {
+	tests := []struct {
+		vertRate int32
+		track    float64
+		heading  float64
+		want     string
+	}{
+		{vertRate: 1792, track: 270, heading: math.MaxFloat64, want: " climbing at 1,792 fpm heading 270°"},
+		{vertRate: -832, track: math.MaxFloat64, heading: 5.3, want: " descending at 832 fpm heading 005°"},
+		{vertRate: 64, track: 90, heading: 100, want: " level heading 090°"},
+		{vertRate: math.MaxInt32, track: math.MaxFloat64, heading: math.MaxFloat64, want: ""},
+	}
+
+	for _, tc := range tests {
+		aircraft := aircraftData{vertRate: tc.vertRate, track: tc.track, heading: tc.heading}
+		got := describeMotion(&aircraft)
+		if got != tc.want {
+			t.Fatalf("expected: %v, got: %v", tc.want, got)
+		}
+	}
+}
+
+func Test_formatThousands(t *testing.T) {
+	tests := []struct {
+		n    int32
+		want string
+	}{
+		{n: 0, want: "0"},
+		{n: 999, want: "999"},
+		{n: 1800, want: "1,800"},
+		{n: -12345, want: "-12,345"},
+		{n: 1234567, want: "1,234,567"},
+	}
+
+	for _, tc := range tests {
+		got := formatThousands(tc.n)
+		if got != tc.want {
+			t.Fatalf("expected: %v, got: %v", tc.want, got)
+		}
+	}
+}
+
+// captureSlack sends notifications to Slack for the rest of the test and
+// collects the text of each one
+func captureSlack(t *testing.T) *[]string {
+	var received []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload struct {
+			Text string `json:"text"`
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		_ = json.Unmarshal(body, &payload)
+		received = append(received, payload.Text)
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(server.Close)
+
+	t.Setenv("slackwebhook", server.URL)
+	notifyWas := *notify
+	t.Cleanup(func() { *notify = notifyWas })
+	*notify = "slack"
+
+	return &received
+}
+
+func Test_printEmergencies(t *testing.T) {
+	received := captureSlack(t)
+
+	testKnownAircraft := &KnownAircraft{}
+	testKnownAircraft.Update(0x4840D6, func(aircraft *aircraftData) {
+		aircraft.callsign = "EZY12AB "
+		aircraft.squawk = 0x7700
+		aircraft.altitude = 3000
+	})
+	testKnownAircraft.Update(0x4840D7, func(aircraft *aircraftData) {
+		aircraft.squawk = 0x1234
+	})
+	testKnownAircraft.Update(modeACAddrFlag|0x7500, func(aircraft *aircraftData) {
+		aircraft.squawk = 0x7500
+	})
+
+	alerted := &TweetedAircraft{}
+	printEmergencies(testKnownAircraft, alerted)
+	printEmergencies(testKnownAircraft, alerted)
+
+	want := []string{"https://flightaware.com/live/flight/EZY12AB EZY12AB is squawking 7700 (general emergency) at 3000 ft!"}
+	if !reflect.DeepEqual(*received, want) {
+		t.Fatalf("expected: %v, got: %v", want, *received)
+	}
+}
+
+func Test_printOverhead(t *testing.T) {
+	received := captureSlack(t)
+
+	testKnownAircraft := &KnownAircraft{}
+	testKnownAircraft.Update(0x4840D6, func(aircraft *aircraftData) {
+		aircraft.callsign = "MDI08   "
+		aircraft.category = 0xA5
+		aircraft.altitude = 3000
+		aircraft.setPosition(*baseLat+0.01, *baseLon)
+		aircraft.lastPos = time.Now()
+	})
+	testKnownAircraft.Update(0x4840D7, func(aircraft *aircraftData) {
+		aircraft.callsign = "EZY12AB "
+		aircraft.altitude = 3000
+		aircraft.setPosition(*baseLat+1, *baseLon)
+		aircraft.lastPos = time.Now()
+	})
+
+	radius := 3
+	tweeted := &TweetedAircraft{}
+	printOverhead(testKnownAircraft, tweeted, &radius)
+	printOverhead(testKnownAircraft, tweeted, &radius)
+
+	want := []string{"https://flightaware.com/live/flight/MDI08 MDI08, a heavy jet, flew 0.69 miles from my house at 3000 ft!"}
+	if !reflect.DeepEqual(*received, want) {
+		t.Fatalf("expected: %v, got: %v", want, *received)
+	}
+}
@@ -1,11 +1,14 @@
 package main
 
 import (
-	"bufio"
+	"bytes"
 	"flag"
 	"log"
+	"math"
 	"net"
-	"reflect"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/coreos/go-systemd/daemon"
@@ -14,20 +17,27 @@ import (
 
 var magicTimestampMLAT = []byte{0xFF, 0x00, 0x4D, 0x4C, 0x41, 0x54}
 
-const (
-	aisCharset = "@ABCDEFGHIJKLMNOPQRSTUVWXYZ[\\]^_ !\"#$%&'()*+,-./0123456789:;<=>?"
-)
-
 var (
-	serverMode  = flag.String("serverMode", "client", "Act as client or server")
-	listenAddr  = flag.String("bind", "127.0.0.1:8081", "\":port\" or \"ip:port\" to bind the server to")
-	baseLat     = flag.Float64("baseLat", 55.910838, "latitude used for distance calculation")
-	baseLon     = flag.Float64("baseLon", -3.236900, "longitude for distance calculation")
-	mode        = flag.String("mode", "overhead", "overhead or table")
-	radius      = flag.Int("radius", 3, "Radius to alert on")
-	feeder      = flag.String("feeder", "192.168.1.50:30005", "IP and port of BEAST feed")
-	cleanupTime = flag.Int("cleanupTimeout", 60, "number of seconds after last contact before cleanup")
-	notify      = flag.String("notify", "both", "Where to send notifications: twitter, slack, or both")
+	serverMode        = flag.String("serverMode", "client", "Act as client or server")
+	listenAddr        = flag.String("bind", "127.0.0.1:8081", "\":port\" or \"ip:port\" to bind the server to")
+	baseLat           = flag.Float64("baseLat", 55.910838, "latitude used for distance calculation")
+	baseLon           = flag.Float64("baseLon", -3.236900, "longitude for distance calculation")
+	mode              = flag.String("mode", "overhead", "overhead or table")
+	radius            = flag.Int("radius", 3, "Radius to alert on")
+	feeder            = flag.String("feeder", "192.168.1.50:30005", "IP and port of BEAST feed, or a comma separated list to fail over between with the primary first")
+	feederFormat      = flag.String("feederFormat", "beast", "Format of -feeder and server mode connections: beast, avr or sbs, or json to poll -feeder as aircraft.json URLs")
+	feederTimeout     = flag.Int("feederTimeout", 60, "Seconds without data before reconnecting to the feeder, 0 to wait forever")
+	cleanupTime       = flag.Int("cleanupTimeout", 60, "number of seconds after last contact before cleanup")
+	notify            = flag.String("notify", "both", "Where to send notifications: twitter, slack, or both")
+	maxRange          = flag.Float64("maxRange", 300, "Maximum range of the receiver in miles, positions further away are discarded")
+	categories        = flag.String("categories", "", "Comma separated emitter categories to alert on, e.g. A5,A7 or none for aircraft that don't report one. Empty alerts on everything")
+	emergencyCooldown = flag.Int("emergencyCooldown", 1800, "Seconds before alerting about the same emergency again")
+	fixErrors         = flag.Int("fixErrors", 1, "Number of bit errors to repair in DF17 frames: 0, 1 or 2")
+	stateFile         = flag.String("stateFile", "overmyhouse.state", "File to keep aircraft and notifications in across restarts, empty to disable")
+	stateInterval     = flag.Int("stateInterval", 30, "Seconds between saves of the state file")
+	trackLength       = flag.Int("trackLength", 120, "Number of positions to keep in each aircraft's track history, 0 to keep none")
+	trackAge          = flag.Int("trackAge", 600, "Seconds of each aircraft's track history to keep")
+	pollInterval      = flag.Int("pollInterval", 1, "Seconds between polls of json sources")
 )
 
 func main() {
@@ -41,15 +51,49 @@ func main() {
 
 	log.Println("Starting to watch over my house")
 
+	var sources sourceFlags
+	flag.Var(&sources, "source", "A named receiver to merge in, as name=host:port with any backup feeders after a comma. Add :avr or :sbs to the name for AVR text or SBS-1 BaseStation records, e.g. friend:avr=host:30002, or :json to poll aircraft.json URLs, e.g. tar1090:json=http://host/tar1090/data/aircraft.json. May be given more than once, -feeder is used if it isn't given at all")
 	flag.Parse()
+	modeSDecoder.FixErrors = *fixErrors
+
+	if !validFormat(*feederFormat) || *serverMode == "server" && *feederFormat == formatJSON {
+		log.Fatalf("Can't take %s from the feeder\n", *feederFormat)
+	}
+
+	switch {
+	case *serverMode == "server":
+		sources = sourceFlags{&feedSource{name: "server", feeders: *listenAddr, format: *feederFormat}}
+	case len(sources) == 0:
+		sources = sourceFlags{&feedSource{name: "feeder", feeders: *feeder, format: *feederFormat}}
+	}
 
 	var knownAircraft KnownAircraft
 	var tweetedAircraft TweetedAircraft
+	var emergencyAircraft TweetedAircraft
 
-	conns := startClient(*feeder)
-	if *serverMode == "server" {
-		server, _ := net.Listen("tcp", *listenAddr)
-		conns = startServer(server)
+	if *stateFile != "" {
+		err := loadState(*stateFile, time.Now(), time.Duration(*cleanupTime)*time.Second,
+			time.Duration(*emergencyCooldown)*time.Second, &knownAircraft, &tweetedAircraft, &emergencyAircraft)
+		if err != nil {
+			log.Printf("Couldn't restore state: %v\n", err)
+		}
+
+		// Save on the way out too, docker stops us with SIGTERM
+		stop := make(chan struct{})
+		saved := make(chan struct{})
+		go func() {
+			saveStateEvery(time.Duration(*stateInterval)*time.Second, *stateFile, stop,
+				&knownAircraft, &tweetedAircraft, &emergencyAircraft)
+			close(saved)
+		}()
+		go func() {
+			signals := make(chan os.Signal, 1)
+			signal.Notify(signals, syscall.SIGTERM, os.Interrupt)
+			<-signals
+			close(stop)
+			<-saved
+			os.Exit(0)
+		}()
 	}
 
 	ticker := time.NewTicker(500 * time.Millisecond)
@@ -65,10 +109,12 @@ func main() {
 					knownAircraft.pruneKnown(time.Now(), uint32(*cleanupTime))
 				default:
 					printOverhead(&knownAircraft, &tweetedAircraft, radius)
+					printEmergencies(&knownAircraft, &emergencyAircraft)
 					tweetedAircraft.pruneTweeted()
+					emergencyAircraft.pruneTweetedAfter(int64(*emergencyCooldown))
 					logCount += 500
 					if logCount == 30000 {
-						printStats(&knownAircraft, &tweetedAircraft)
+						printStats(&knownAircraft, &tweetedAircraft, sources)
 						logCount = 0
 					}
 					knownAircraft.pruneKnown(time.Now(), uint32(*cleanupTime))
@@ -82,9 +128,28 @@ func main() {
 
 	_, _ = daemon.SdNotify(false, "READY=1")
 
-	for {
-		go handleConnection(<-conns, &knownAircraft)
+	if *serverMode == "server" {
+		server, _ := net.Listen("tcp", *listenAddr)
+		conns := startServer(server)
+		for {
+			go handleConnection(<-conns, sources[0], &knownAircraft)
+		}
 	}
+
+	for _, source := range sources {
+		if source.format == formatJSON {
+			go newJSONPoller(source, time.Duration(*pollInterval)*time.Second).run(&knownAircraft, nil)
+			continue
+		}
+
+		client := newFeedClient(source.name, source.feeders, time.Duration(*feederTimeout)*time.Second)
+		go client.run(func(source *feedSource) func(conn net.Conn) {
+			return func(conn net.Conn) {
+				handleConnection(conn, source, &knownAircraft)
+			}
+		}(source), nil)
+	}
+	select {}
 }
 
 func startServer(listener net.Listener) chan net.Conn {
@@ -101,82 +166,60 @@ func startServer(listener net.Listener) chan net.Conn {
 	return ch
 }
 
-func startClient(feeder string) chan net.Conn {
-	ch := make(chan net.Conn)
-	go func() {
-		con, err := net.Dial("tcp", feeder)
-		if err != nil {
-			// retry once if the first attempt fails
-			con, _ = net.Dial("tcp", feeder)
-		}
-		ch <- con
-	}()
-
-	return ch
-}
-
-func handleConnection(conn net.Conn, knownAircraft *KnownAircraft) {
-	reader := bufio.NewReader(conn)
+// handleConnection tracks the frames from one connection to a source until it
+// closes, skipping any another source has already sent us
+func handleConnection(conn net.Conn, source *feedSource, knownAircraft *KnownAircraft) {
+	if source.format == formatSBS {
+		stats := handleSBSConnection(conn, source, knownAircraft)
+		log.Printf("Connection to %s closed, records: %d skipped: %d malformed: %d\n",
+			source.name, stats.frames, stats.dropped, stats.malformed)
+		return
+	}
 
-	var bufferedMessage []byte
+	reader := source.newFrameReader(conn)
+	var decodeErrors decodeStats
 
 	for {
-		currentMessage, _ := reader.ReadBytes(0x1A)
-
-		if len(currentMessage) == 0 {
+		frame, err := reader.readFrame()
+		if err != nil {
 			break
 		}
 
-		if bufferedMessage == nil {
-			bufferedMessage = currentMessage
-		} else {
-			bufferedMessage = append(bufferedMessage, currentMessage...)
-		}
-
-		parseBuffer := false
-		if currentMessage[0] == 0x31 || currentMessage[0] == 0x32 ||
-			currentMessage[0] == 0x33 || currentMessage[0] == 0x34 {
-			parseBuffer = true
-		}
-		if !parseBuffer {
+		switch frame.msgType {
+		case beastModeAC, beastModeSShort, beastModeSLong:
+		default:
 			continue
 		}
 
-		message := bufferedMessage
-		bufferedMessage = nil
-
-		msgType := message[0]
-		var msgLen int
-
-		switch msgType {
-		case 0x31:
-			continue
-		case 0x32:
-			continue
-		case 0x33:
-			msgLen = 22
-		case 0x34:
-			continue
-		default:
+		source.countFrame()
+		if receivedFrames.duplicate(frame.payload, source, time.Now()) {
+			source.countDuplicate()
 			continue
 		}
 
-		if len(message) < msgLen {
+		if frame.msgType == beastModeAC {
+			decodeErrors.count(parseModeAC(frame.payload, knownAircraft))
 			continue
 		}
 
 		// Not sure if MLAT stuff is necessary
 		var timestamp time.Time
-		isMlat := reflect.DeepEqual(message[1:7], magicTimestampMLAT)
+		isMlat := bytes.Equal(frame.timestamp, magicTimestampMLAT)
 		if !isMlat {
 			utcDate := time.Now().UTC()
-			timestamp = parseTime(message[1:7], utcDate)
+			timestamp = parseTime(frame.timestamp, utcDate)
 			_ = timestamp // Why?!
 		}
 
-		msgContent := message[8 : len(message)-1]
-
-		parseModeS(msgContent, isMlat, knownAircraft)
+		distance, err := parseModeS(frame.payload, isMlat, knownAircraft)
+		decodeErrors.count(err)
+		if distance != math.MaxFloat64 {
+			source.countPosition(distance)
+		}
 	}
 
+	stats := reader.frameStats()
+	log.Printf("Connection to %s closed, frames: %d dropped: %d malformed: %d bad length: %d bad CRC: %d unsupported: %d\n",
+		source.name, stats.frames, stats.dropped, stats.malformed,
+		decodeErrors.length, decodeErrors.crc, decodeErrors.unsupported)
 }
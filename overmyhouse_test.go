@@ -1,19 +1,95 @@
 package main
 
 import (
+	"net"
 	"testing"
-	"time"
 )
 
-// Test that startClient does not panic when connection cannot be established.
-func TestStartClient_NoPanic(t *testing.T) {
-	ch := startClient("invalid:0")
-	select {
-	case conn := <-ch:
-		if conn != nil {
-			conn.Close()
-		}
-	case <-time.After(1 * time.Second):
-		t.Fatal("startClient did not return")
+func TestHandleConnection(t *testing.T) {
+	receivedFrames = newFrameDeduper(duplicateWindow)
+	testKnownAircraft := &KnownAircraft{}
+	server, client := net.Pipe()
+
+	go func() {
+		// An all-call reply, then one cut short by the connection closing
+		_, _ = server.Write([]byte{0x1A, 0x32, 1, 2, 3, 4, 5, 6, 7, 93, 72, 64, 214, 248, 116, 15})
+		_, _ = server.Write([]byte{0x1A, 0x33, 1, 2, 3, 4, 5, 6, 7, 141, 64})
+		server.Close()
+	}()
+
+	handleConnection(client, &feedSource{name: "test"}, testKnownAircraft)
+
+	if _, known := testKnownAircraft.getAircraft(0x4840D6); !known || testKnownAircraft.getNumberOfKnown() != 1 {
+		t.Fatalf("expected only 4840d6 to be known, got: %v", testKnownAircraft.getNumberOfKnown())
+	}
+}
+
+func TestHandleConnectionMergesSources(t *testing.T) {
+	receivedFrames = newFrameDeduper(duplicateWindow)
+	testKnownAircraft := &KnownAircraft{}
+	roof, shed := &feedSource{name: "roof"}, &feedSource{name: "shed"}
+
+	// Both antennas hear the all-call, only the shed hears the altitude reply
+	allCall := []byte{0x1A, 0x32, 1, 2, 3, 4, 5, 6, 7, 93, 72, 64, 214, 248, 116, 15}
+	for _, source := range []*feedSource{roof, shed} {
+		server, client := net.Pipe()
+		go func(source *feedSource) {
+			_, _ = server.Write(allCall)
+			if source == shed {
+				_, _ = server.Write([]byte{0x1A, 0x32, 1, 2, 3, 4, 5, 6, 7, 32, 0, 24, 56, 89, 195, 141})
+			}
+			server.Close()
+		}(source)
+		handleConnection(client, source, testKnownAircraft)
+	}
+
+	if roof.frames != 1 || shed.frames != 2 {
+		t.Fatalf("expected 1 and 2 frames, got: %v %v", roof.frames, shed.frames)
+	}
+	if roof.duplicates != 0 || shed.duplicates != 1 {
+		t.Fatalf("expected the shed's all-call to be a duplicate, got: %v %v", roof.duplicates, shed.duplicates)
+	}
+	aircraft, known := testKnownAircraft.getAircraft(0x4840D6)
+	if !known || aircraft.altitude != 38000 {
+		t.Fatalf("expected 4840d6 at 38000 from the shed")
+	}
+}
+
+func TestHandleConnectionAVR(t *testing.T) {
+	receivedFrames = newFrameDeduper(duplicateWindow)
+	testKnownAircraft := &KnownAircraft{}
+	server, client := net.Pipe()
+
+	go func() {
+		_, _ = server.Write([]byte("*8D4840D6202CC371C32CE0576098;\n"))
+		server.Close()
+	}()
+
+	handleConnection(client, &feedSource{name: "friend", format: formatAVR}, testKnownAircraft)
+
+	aircraft, known := testKnownAircraft.getAircraft(0x4840D6)
+	if !known || aircraft.callsign != "KLM1023 " {
+		t.Fatalf("expected KLM1023 from AVR")
+	}
+}
+
+func TestHandleConnectionSBS(t *testing.T) {
+	testKnownAircraft := &KnownAircraft{}
+	server, client := net.Pipe()
+
+	go func() {
+		_, _ = server.Write([]byte("MSG,1,1,1,4840D6,1,2024/01/01,12:00:00.000,2024/01/01,12:00:00.000,KLM1023,,,,,,,,,,,0\r\n"))
+		server.Close()
+	}()
+
+	source := &feedSource{name: "club", format: formatSBS}
+	handleConnection(client, source, testKnownAircraft)
+
+	aircraft, known := testKnownAircraft.getAircraft(0x4840D6)
+	if !known || aircraft.callsign != "KLM1023 " {
+		t.Fatalf("expected KLM1023 from SBS")
+	}
+	if source.frames != 1 {
+		t.Fatalf("expected: 1, got: %v", source.frames)
 	}
 }
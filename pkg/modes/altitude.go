@@ -0,0 +1,135 @@
+package modes
+
+import (
+	"math"
+)
+
+// decodeAC12Field decodes the altitude in an airborne position squitter.
+// Unlike AC13 there is no M bit, the field is always in feet.
+func decodeAC12Field(ac12Data uint) int32 {
+	q := (ac12Data & 0x10) == 0x10
+	if q {
+		n := int32((ac12Data&0x0FE0)>>1) + int32(ac12Data&0x000F)
+		return (n * 25) - 1000
+	}
+
+	// Make N a 13 bit Gillham coded altitude by inserting M=0 at bit 6
+	n := ((ac12Data & 0x0FC0) << 1) | (ac12Data & 0x003F)
+	return decodeGillhamAltitude(n)
+}
+
+// decodeAC13Field decodes the altitude code in DF0/4/16/20 replies, which
+// may be in 25 ft steps, Gillham coded 100 ft steps or metres.
+func decodeAC13Field(ac13Data uint) int32 {
+	m := (ac13Data & 0x0040) == 0x0040
+	q := (ac13Data & 0x0010) == 0x0010
+
+	if m {
+		// The other 12 bits are the altitude in metres
+		meters := ((ac13Data & 0x1F80) >> 1) | (ac13Data & 0x003F)
+		return int32(math.Round(float64(meters) * feetInMeter))
+	}
+
+	if q {
+		n := int32((ac13Data&0x1F80)>>2) + int32((ac13Data&0x0020)>>1) + int32(ac13Data&0x000F)
+		return (n * 25) - 1000
+	}
+
+	return decodeGillhamAltitude(ac13Data)
+}
+
+const feetInMeter = 3.28084
+
+func decodeGillhamAltitude(ac13Data uint) int32 {
+	n, ok := modeAToModeC(decodeID13Field(ac13Data))
+	if !ok {
+		return int32(math.MaxInt32)
+	}
+	return n * 100
+}
+
+// decodeID13Field rearranges the 13 bit identity/altitude field of a Mode S
+// reply into the hex coded octal (0xABCD) layout used for Mode A codes.
+func decodeID13Field(id13Field uint) uint {
+	bits := []struct {
+		id13   uint
+		hexOct uint
+	}{
+		{0x1000, 0x0010}, // C1
+		{0x0800, 0x1000}, // A1
+		{0x0400, 0x0020}, // C2
+		{0x0200, 0x2000}, // A2
+		{0x0100, 0x0040}, // C4
+		{0x0080, 0x4000}, // A4
+		// 0x0040 is X or M
+		{0x0020, 0x0100}, // B1
+		{0x0010, 0x0001}, // D1 or Q
+		{0x0008, 0x0200}, // B2
+		{0x0004, 0x0002}, // D2
+		{0x0002, 0x0400}, // B4
+		{0x0001, 0x0004}, // D4
+	}
+
+	var hexGillham uint
+	for _, bit := range bits {
+		if id13Field&bit.id13 > 0 {
+			hexGillham |= bit.hexOct
+		}
+	}
+	return hexGillham
+}
+
+// modeAToModeC converts a Gillham coded altitude, laid out as a hex coded
+// octal Mode A code (0xABCD), into hundreds of feet.
+func modeAToModeC(modeA uint) (int32, bool) {
+	// D1 is never used for altitude and C1-C4 can't all be zero
+	if (modeA&0xFFFF8889) != 0 || (modeA&0x00F0) == 0 {
+		return 0, false
+	}
+
+	var fiveHundreds, oneHundreds int32
+
+	if modeA&0x0010 > 0 { // C1
+		oneHundreds ^= 0x007
+	}
+	if modeA&0x0020 > 0 { // C2
+		oneHundreds ^= 0x003
+	}
+	if modeA&0x0040 > 0 { // C4
+		oneHundreds ^= 0x001
+	}
+
+	// Remove 7s from the hundreds (7 -> 5, 5 -> 7)
+	if (oneHundreds & 5) == 5 {
+		oneHundreds ^= 2
+	}
+	if oneHundreds > 5 {
+		return 0, false
+	}
+
+	grayBits := []struct {
+		mask uint
+		xor  int32
+	}{
+		{0x0002, 0x0FF}, // D2
+		{0x0004, 0x07F}, // D4
+		{0x1000, 0x03F}, // A1
+		{0x2000, 0x01F}, // A2
+		{0x4000, 0x00F}, // A4
+		{0x0100, 0x007}, // B1
+		{0x0200, 0x003}, // B2
+		{0x0400, 0x001}, // B4
+	}
+	for _, bit := range grayBits {
+		if modeA&bit.mask > 0 {
+			fiveHundreds ^= bit.xor
+		}
+	}
+
+	// Odd five hundreds count the hundreds backwards
+	if fiveHundreds&1 > 0 {
+		oneHundreds = 6 - oneHundreds
+	}
+
+	return (fiveHundreds * 5) + oneHundreds - 13, true
+}
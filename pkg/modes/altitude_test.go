@@ -0,0 +1,105 @@
+package modes
+
+import (
+	"reflect"
+	"testing"
+)
+
+func Test_decodeAC12Field(t *testing.T) {
+	tests := []struct {
+		input uint
+		want  int32
+	}{
+		{input: 1, want: 2147483647},
+		{input: 16, want: -1000},
+		{input: 0xC38, want: 38000},
+		// Gillham coded
+		{input: 0x842, want: 6200},
+		{input: 0x600, want: 30500},
+		{input: 0x000, want: 2147483647},
+	}
+
+	for _, tc := range tests {
+		got := decodeAC12Field(tc.input)
+		if !reflect.DeepEqual(tc.want, got) {
+			t.Fatalf("expected: %v, got: %v", tc.want, got)
+		}
+	}
+
+}
+
+func Test_decodeAC13Field(t *testing.T) {
+	tests := []struct {
+		input uint
+		want  int32
+	}{
+		{input: 0x1838, want: 38000},
+		{input: 0x0010, want: -1000},
+		// Gillham coded
+		{input: 0x1082, want: 6200},
+		{input: 0x0C00, want: 30500},
+		{input: 0x1000, want: -800},
+		{input: 0x0000, want: 2147483647},
+		// Metres
+		{input: 0x07E8, want: 3281},
+		{input: 0x0040, want: 0},
+	}
+
+	for _, tc := range tests {
+		got := decodeAC13Field(tc.input)
+		if !reflect.DeepEqual(tc.want, got) {
+			t.Fatalf("%04x: expected: %v, got: %v", tc.input, tc.want, got)
+		}
+	}
+}
+
+func Test_decodeID13Field(t *testing.T) {
+	tests := []struct {
+		input uint
+		want  uint
+	}{
+		{input: 0x1082, want: 0x4410},
+		{input: 0x0C00, want: 0x1020},
+		{input: 0x1FBF, want: 0x7777},
+		{input: 0x0040, want: 0x0000},
+	}
+
+	for _, tc := range tests {
+		got := decodeID13Field(tc.input)
+		if got != tc.want {
+			t.Fatalf("%04x: expected: %04x, got: %04x", tc.input, tc.want, got)
+		}
+	}
+}
+
+func Test_modeAToModeC(t *testing.T) {
+	tests := []struct {
+		modeA uint
+		want  int32
+		valid bool
+	}{
+		{modeA: 0x0010, want: -8, valid: true},
+		{modeA: 0x0020, want: -10, valid: true},
+		{modeA: 0x0030, want: -9, valid: true},
+		{modeA: 0x0040, want: -12, valid: true},
+		{modeA: 0x0240, want: 7, valid: true},
+		{modeA: 0x4410, want: 62, valid: true},
+		{modeA: 0x2030, want: 144, valid: true},
+		{modeA: 0x1020, want: 305, valid: true},
+		{modeA: 0x0000, valid: false},
+		{modeA: 0x0002, valid: false},
+		{modeA: 0x0050, valid: false},
+		{modeA: 0x0011, valid: false},
+		{modeA: 0x7700, valid: false},
+	}
+
+	for _, tc := range tests {
+		got, valid := modeAToModeC(tc.modeA)
+		if valid != tc.valid {
+			t.Fatalf("%04x: expected valid %v, got %v", tc.modeA, tc.valid, valid)
+		}
+		if valid && got != tc.want {
+			t.Fatalf("%04x: expected: %v, got: %v", tc.modeA, tc.want, got)
+		}
+	}
+}
@@ -0,0 +1,270 @@
+package modes
+
+import (
+	"math"
+	"strings"
+)
+
+// Comm-B Data Selector registers we know how to read
+// https://mode-s.org/decode/content/mode-s/9-ehs.html
+const (
+	bds20 = 0x20 // Aircraft identification
+	bds40 = 0x40 // Selected vertical intention
+	bds50 = 0x50 // Track and turn report
+	bds60 = 0x60 // Heading and speed report
+)
+
+// CommB is a decoded Comm-B register. Values the register doesn't carry, or
+// that are flagged invalid, are math.MaxFloat64.
+type CommB struct {
+	BDS uint8
+
+	// BDS 2,0
+	Callsign string
+
+	// BDS 4,0
+	SelectedAltitude float64 // ft, MCP/FCU
+	FMSAltitude      float64 // ft
+	BaroSetting      float64 // mb
+
+	// BDS 5,0
+	RollAngle    float64 // degrees, negative is left wing down
+	TrueTrack    float64 // degrees
+	TrueAirspeed float64 // kt
+
+	// BDS 6,0
+	MagHeading        float64 // degrees
+	IndicatedAirspeed float64 // kt
+	Mach              float64
+	BaroVertRate      float64 // ft/min
+	InertialVertRate  float64 // ft/min
+}
+
+// mbBits returns bits first to last of the 56 bit MB field of a DF20/21 reply
+func mbBits(message []byte, first uint, last uint) uint {
+	return getBits(message, 32+first, 32+last)
+}
+
+// signedMBBits reads a sign bit followed by a two's complement value
+func signedMBBits(message []byte, sign uint, first uint, last uint) int {
+	value := int(mbBits(message, first, last))
+	if mbBits(message, sign, sign) == 1 {
+		value -= 1 << (last - first + 1)
+	}
+	return value
+}
+
+// statusMatches checks a field is either flagged valid or left as all zeros,
+// the first thing to rule out a guess at the register.
+func statusMatches(message []byte, status uint, first uint, last uint) bool {
+	return mbBits(message, status, status) == 1 || mbBits(message, first, last) == 0
+}
+
+// inferBDS works out which register a Comm-B reply holds. Replies don't say,
+// so we try each register's layout and only accept one that alone makes sense.
+func inferBDS(message []byte) uint8 {
+	if mbBits(message, 1, 56) == 0 {
+		return 0
+	}
+
+	if isBDS20(message) {
+		return bds20
+	}
+
+	var found uint8
+	for _, check := range []struct {
+		bds   uint8
+		valid func([]byte) bool
+	}{
+		{bds40, isBDS40},
+		{bds50, isBDS50},
+		{bds60, isBDS60},
+	} {
+		if check.valid(message) {
+			if found != 0 {
+				return 0
+			}
+			found = check.bds
+		}
+	}
+
+	return found
+}
+
+func isBDS20(message []byte) bool {
+	if mbBits(message, 1, 8) != bds20 {
+		return false
+	}
+
+	callsign := decodeCallsign(&message)
+	if strings.TrimSpace(callsign) == "" {
+		return false
+	}
+	for _, c := range callsign {
+		if !(c >= 'A' && c <= 'Z') && !(c >= '0' && c <= '9') && c != ' ' {
+			return false
+		}
+	}
+	return true
+}
+
+func isBDS40(message []byte) bool {
+	if !statusMatches(message, 1, 2, 13) || !statusMatches(message, 14, 15, 26) ||
+		!statusMatches(message, 27, 28, 39) {
+		return false
+	}
+
+	// Reserved bits
+	if mbBits(message, 40, 47) != 0 || mbBits(message, 52, 53) != 0 {
+		return false
+	}
+
+	if mbBits(message, 1, 1) == 0 && mbBits(message, 14, 14) == 0 && mbBits(message, 27, 27) == 0 {
+		return false
+	}
+
+	if mbBits(message, 1, 1) == 1 && mbBits(message, 2, 13)*16 > 50000 {
+		return false
+	}
+	if mbBits(message, 14, 14) == 1 && mbBits(message, 15, 26)*16 > 50000 {
+		return false
+	}
+	if mbBits(message, 27, 27) == 1 {
+		baro := float64(mbBits(message, 28, 39))*0.1 + 800
+		if baro < 900 || baro > 1100 {
+			return false
+		}
+	}
+
+	return true
+}
+
+func isBDS50(message []byte) bool {
+	if !statusMatches(message, 1, 2, 11) || !statusMatches(message, 12, 13, 23) ||
+		!statusMatches(message, 24, 25, 34) || !statusMatches(message, 35, 36, 45) ||
+		!statusMatches(message, 46, 47, 56) {
+		return false
+	}
+
+	if mbBits(message, 1, 1) == 1 {
+		roll := float64(signedMBBits(message, 2, 3, 11)) * 45 / 256
+		if roll < -50 || roll > 50 {
+			return false
+		}
+	}
+
+	groundSpeed := mbBits(message, 25, 34) * 2
+	trueAirspeed := mbBits(message, 47, 56) * 2
+	if groundSpeed > 600 || trueAirspeed > 500 {
+		return false
+	}
+	if mbBits(message, 24, 24) == 1 && mbBits(message, 46, 46) == 1 {
+		diff := int(groundSpeed) - int(trueAirspeed)
+		if diff > 200 || diff < -200 {
+			return false
+		}
+	}
+
+	return true
+}
+
+func isBDS60(message []byte) bool {
+	if !statusMatches(message, 1, 2, 12) || !statusMatches(message, 13, 14, 23) ||
+		!statusMatches(message, 24, 25, 34) || !statusMatches(message, 35, 36, 45) ||
+		!statusMatches(message, 46, 47, 56) {
+		return false
+	}
+
+	if mbBits(message, 14, 23) > 500 {
+		return false
+	}
+	if float64(mbBits(message, 25, 34))*2.048/512 > 1 {
+		return false
+	}
+
+	for _, vertRate := range []int{signedMBBits(message, 36, 37, 45), signedMBBits(message, 47, 48, 56)} {
+		if vertRate*32 > 6000 || vertRate*32 < -6000 {
+			return false
+		}
+	}
+
+	return true
+}
+
+// decodeCommB reads the MB field of a DF20/21 reply, returning nil if we
+// can't tell which register it holds
+func decodeCommB(message []byte) *CommB {
+	bds := inferBDS(message)
+	if bds == 0 {
+		return nil
+	}
+
+	commB := &CommB{
+		BDS:               bds,
+		SelectedAltitude:  math.MaxFloat64,
+		FMSAltitude:       math.MaxFloat64,
+		BaroSetting:       math.MaxFloat64,
+		RollAngle:         math.MaxFloat64,
+		TrueTrack:         math.MaxFloat64,
+		TrueAirspeed:      math.MaxFloat64,
+		MagHeading:        math.MaxFloat64,
+		IndicatedAirspeed: math.MaxFloat64,
+		Mach:              math.MaxFloat64,
+		BaroVertRate:      math.MaxFloat64,
+		InertialVertRate:  math.MaxFloat64,
+	}
+
+	switch bds {
+	case bds20:
+		commB.Callsign = decodeCallsign(&message)
+
+	case bds40:
+		if mbBits(message, 1, 1) == 1 {
+			commB.SelectedAltitude = float64(mbBits(message, 2, 13) * 16)
+		}
+		if mbBits(message, 14, 14) == 1 {
+			commB.FMSAltitude = float64(mbBits(message, 15, 26) * 16)
+		}
+		if mbBits(message, 27, 27) == 1 {
+			commB.BaroSetting = float64(mbBits(message, 28, 39))*0.1 + 800
+		}
+
+	case bds50:
+		if mbBits(message, 1, 1) == 1 {
+			commB.RollAngle = float64(signedMBBits(message, 2, 3, 11)) * 45 / 256
+		}
+		if mbBits(message, 12, 12) == 1 {
+			track := float64(signedMBBits(message, 13, 14, 23)) * 90 / 512
+			if track < 0 {
+				track += 360
+			}
+			commB.TrueTrack = track
+		}
+		if mbBits(message, 46, 46) == 1 {
+			commB.TrueAirspeed = float64(mbBits(message, 47, 56) * 2)
+		}
+
+	case bds60:
+		if mbBits(message, 1, 1) == 1 {
+			heading := float64(signedMBBits(message, 2, 3, 12)) * 90 / 512
+			if heading < 0 {
+				heading += 360
+			}
+			commB.MagHeading = heading
+		}
+		if mbBits(message, 13, 13) == 1 {
+			commB.IndicatedAirspeed = float64(mbBits(message, 14, 23))
+		}
+		if mbBits(message, 24, 24) == 1 {
+			commB.Mach = float64(mbBits(message, 25, 34)) * 2.048 / 512
+		}
+		if mbBits(message, 35, 35) == 1 {
+			commB.BaroVertRate = float64(signedMBBits(message, 36, 37, 45) * 32)
+		}
+		if mbBits(message, 46, 46) == 1 {
+			commB.InertialVertRate = float64(signedMBBits(message, 47, 48, 56) * 32)
+		}
+	}
+
+	return commB
+}
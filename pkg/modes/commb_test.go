@@ -0,0 +1,67 @@
+package modes
+
+import (
+	"math"
+	"testing"
+)
+
+var (
+	testBDS20 = []byte{160, 0, 8, 62, 32, 44, 195, 113, 195, 29, 224, 170, 28, 207}
+	testBDS40 = []byte{160, 0, 2, 156, 133, 228, 47, 49, 48, 0, 0, 112, 71, 211}
+	testBDS50 = []byte{160, 0, 19, 147, 129, 149, 21, 54, 224, 36, 212, 204, 246, 181}
+	testBDS60 = []byte{160, 0, 4, 18, 143, 57, 249, 26, 126, 39, 196, 106, 220, 33}
+)
+
+func Test_inferBDS(t *testing.T) {
+	tests := []struct {
+		message []byte
+		want    uint8
+	}{
+		{message: testBDS20, want: bds20},
+		{message: testBDS40, want: bds40},
+		{message: testBDS50, want: bds50},
+		{message: testBDS60, want: bds60},
+		{message: []byte{160, 0, 4, 18, 0, 0, 0, 0, 0, 0, 0, 106, 220, 33}, want: 0},
+	}
+
+	for _, tc := range tests {
+		got := inferBDS(tc.message)
+		if got != tc.want {
+			t.Fatalf("%v: expected: %02x, got: %02x", tc.message, tc.want, got)
+		}
+	}
+}
+
+func Test_decodeCommB(t *testing.T) {
+	tests := []struct {
+		name  string
+		value float64
+		want  float64
+	}{
+		{name: "selected altitude", value: decodeCommB(testBDS40).SelectedAltitude, want: 3008},
+		{name: "FMS altitude", value: decodeCommB(testBDS40).FMSAltitude, want: 3008},
+		{name: "baro setting", value: decodeCommB(testBDS40).BaroSetting, want: 1020},
+		{name: "roll angle", value: decodeCommB(testBDS50).RollAngle, want: 2.1},
+		{name: "true track", value: decodeCommB(testBDS50).TrueTrack, want: 114.258},
+		{name: "true airspeed", value: decodeCommB(testBDS50).TrueAirspeed, want: 424},
+		{name: "magnetic heading", value: decodeCommB(testBDS60).MagHeading, want: 42.715},
+		{name: "indicated airspeed", value: decodeCommB(testBDS60).IndicatedAirspeed, want: 252},
+		{name: "mach", value: decodeCommB(testBDS60).Mach, want: 0.42},
+		{name: "baro vertical rate", value: decodeCommB(testBDS60).BaroVertRate, want: -1920},
+		{name: "inertial vertical rate", value: decodeCommB(testBDS60).InertialVertRate, want: -1920},
+		{name: "not in register", value: decodeCommB(testBDS60).RollAngle, want: math.MaxFloat64},
+	}
+
+	for _, tc := range tests {
+		if math.Abs(tc.value-tc.want) > 0.01 {
+			t.Fatalf("%s: expected: %v, got: %v", tc.name, tc.want, tc.value)
+		}
+	}
+
+	if callsign := decodeCommB(testBDS20).Callsign; callsign != "KLM1017 " {
+		t.Fatalf("expected: KLM1017, got: %v", callsign)
+	}
+	if decodeCommB([]byte{160, 0, 4, 18, 0, 0, 0, 0, 0, 0, 0, 106, 220, 33}) != nil {
+		t.Fatalf("expected an empty register to be left undecoded")
+	}
+}
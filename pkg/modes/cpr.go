@@ -0,0 +1,230 @@
+package modes
+
+import (
+	"math"
+)
+
+func cprNLFunction(lat float64) byte {
+	lat = abs(lat) // Ensure latitude is positive for comparison
+
+	latThresholds := []float64{
+		10.47047130, 14.82817437, 18.18626357, 21.02939493, 23.54504487, 25.82924707,
+		27.93898710, 29.91135686, 31.77209708, 33.53993436, 35.22899598, 36.85025108,
+		38.41241892, 39.92256684, 41.38651832, 42.80914012, 44.19454951, 45.54626723,
+		46.86733252, 48.16039128, 49.42776439, 50.67150166, 51.89342469, 53.09516153,
+		54.27817472, 55.44378444, 56.59318756, 57.72747354, 58.84763776, 59.95459277,
+		61.04917774, 62.13216659, 63.20427479, 64.26616523, 65.31845310, 66.36171008,
+		67.39646774, 68.42322022, 69.44242631, 70.45451075, 71.45986473, 72.45884545,
+		73.45177442, 74.43893416, 75.42056257, 76.39684391, 77.36789461, 78.33374083,
+		79.29428225, 80.24923213, 81.19801349, 82.13956981, 83.07199445, 83.99173563,
+		84.89166191, 85.75541621, 86.53536998, 87.00000000,
+	}
+
+	// Iterate over the thresholds to find the appropriate NL value
+	for i, threshold := range latThresholds {
+		if lat < threshold {
+			return byte(59 - i)
+		}
+	}
+
+	// Default case if latitude exceeds all thresholds
+	return 1
+}
+
+// Helper function to calculate the absolute value of a float
+func abs(val float64) float64 {
+	if val < 0 {
+		return -val
+	}
+	return val
+}
+
+func cprNFunction(lat float64, fflag bool) byte {
+	// Descriptive variable for offset based on fflag
+	fflagOffset := byte(1)
+	if !fflag {
+		fflagOffset = 0
+	}
+
+	// Calculate the adjusted NL value
+	return calculateNL(lat, fflagOffset)
+}
+
+// Helper function to calculate NL value, encapsulating logic for NL adjustments
+func calculateNL(lat float64, offset byte) byte {
+	nl := cprNLFunction(lat) - offset
+	if nl < 1 {
+		nl = 1
+	}
+	return nl
+}
+
+func cprDlonFunction(lat float64, fflag bool, surface bool) float64 {
+	var sfc float64
+	if surface {
+		sfc = 90.0
+	} else {
+		sfc = 360.0
+	}
+
+	return sfc / float64(cprNFunction(lat, fflag))
+
+}
+
+// CPR is a position in Compact Position Reporting form. It takes a pair of
+// even and odd frames, or one frame and a reference position nearby, to
+// decode. Both raw values are math.MaxUint32 when there is no position.
+type CPR struct {
+	Lat   uint32
+	Lon   uint32
+	Odd   bool
+	TFlag bool
+}
+
+// DecodeAirborneCPR is the global decode for a pair of airborne positions,
+// giving the position of whichever frame came last.
+func DecodeAirborneCPR(evenLat uint32, evenLon uint32, oddLat uint32,
+	oddLon uint32, lastOdd bool, tFlag bool) (latitude float64, longitude float64) {
+	if evenLat == math.MaxUint32 || oddLat == math.MaxUint32 ||
+		evenLon == math.MaxUint32 || oddLon == math.MaxUint32 {
+		return math.MaxFloat64, math.MaxFloat64
+	}
+
+	// http://www.lll.lu/~edward/edward/adsb/DecodingADSBposition.html
+	j := int32((float64(59*evenLat-60*oddLat) / 131072.0) + 0.5)
+
+	const airdlat0 = float64(6.0)
+	const airdlat1 = float64(360.0) / float64(59.0)
+
+	rlatEven := airdlat0 * (float64(j%60) + float64(evenLat)/131072.0)
+	rlatOdd := airdlat1 * (float64(j%59) + float64(oddLat)/131072.0)
+	if rlatEven >= 270 {
+		rlatEven -= 360
+	}
+	if rlatOdd >= 270 {
+		rlatOdd -= 360
+	}
+
+	nlEven := cprNLFunction(rlatEven)
+	nlOdd := cprNLFunction(rlatOdd)
+
+	if nlEven != nlOdd {
+		return math.MaxFloat64, math.MaxFloat64
+	}
+
+	var ni int16
+
+	if lastOdd {
+		ni = int16(nlOdd) - 1
+	} else {
+		ni = int16(nlEven) - 1
+	}
+	if ni < 1 {
+		ni = 1
+	}
+
+	var outLat float64
+	var outLon float64
+	if tFlag {
+		m := int16(math.Floor((float64(int32(evenLon*uint32(cprNLFunction(rlatOdd)-1))-
+			int32(oddLon*uint32(cprNLFunction(rlatOdd)))) / 131072.0) + 0.5))
+		outLon = cprDlonFunction(rlatOdd, tFlag, false) * (float64(m%ni) + float64(oddLon)/131072.0)
+		outLat = rlatOdd
+
+	} else {
+		m := int16(math.Floor((float64(int32(evenLon*uint32(cprNLFunction(rlatEven)-1))-
+			int32(oddLon*uint32(cprNLFunction(rlatEven)))) / 131072.0) + 0.5))
+		outLon = cprDlonFunction(rlatEven, tFlag, false) * (float64(m%ni) + float64(evenLon)/131072.0)
+		outLat = rlatEven
+	}
+
+	outLon -= math.Floor((outLon+180.0)/360.0) * 360.0
+
+	return outLat, outLon
+}
+
+// DecodeSurfaceCPR is the global decode for surface positions. Surface
+// frames cover a 90 degree zone rather than 360, so each position has four
+// candidates in longitude and two in latitude, and the one nearest the
+// reference point wins.
+func DecodeSurfaceCPR(evenLat uint32, evenLon uint32, oddLat uint32, oddLon uint32,
+	lastOdd bool, refLat float64, refLon float64) (latitude float64, longitude float64) {
+	if evenLat == math.MaxUint32 || oddLat == math.MaxUint32 ||
+		evenLon == math.MaxUint32 || oddLon == math.MaxUint32 {
+		return math.MaxFloat64, math.MaxFloat64
+	}
+
+	const cprMax = 131072.0
+	const dlat0 = 90.0 / 60.0
+	const dlat1 = 90.0 / 59.0
+
+	j := math.Floor((59*float64(evenLat)-60*float64(oddLat))/cprMax + 0.5)
+
+	rlatEven := dlat0 * (positiveMod(j, 60) + float64(evenLat)/cprMax)
+	rlatOdd := dlat1 * (positiveMod(j, 59) + float64(oddLat)/cprMax)
+
+	// Both of these sit in the northern hemisphere, the southern candidate is 90 degrees below
+	if refLat-rlatEven < -45 {
+		rlatEven -= 90
+		rlatOdd -= 90
+	}
+
+	nl := cprNLFunction(rlatEven)
+	if nl != cprNLFunction(rlatOdd) {
+		return math.MaxFloat64, math.MaxFloat64
+	}
+
+	rlat, rawLon, ni := rlatEven, float64(evenLon), float64(nl)
+	if lastOdd {
+		rlat, rawLon, ni = rlatOdd, float64(oddLon), math.Max(float64(nl)-1, 1)
+	}
+
+	m := math.Floor((float64(evenLon)*float64(nl-1)-float64(oddLon)*float64(nl))/cprMax + 0.5)
+	rlon := (90.0 / ni) * (positiveMod(m, ni) + rawLon/cprMax)
+
+	// Pick the longitude zone closest to the reference
+	rlon += math.Floor((refLon-rlon+45)/90) * 90
+	rlon -= math.Floor((rlon+180.0)/360.0) * 360.0
+
+	return rlat, rlon
+}
+
+func positiveMod(a float64, b float64) float64 {
+	res := math.Mod(a, b)
+	if res < 0 {
+		res += b
+	}
+	return res
+}
+
+// DecodeLocalCPR resolves a single CPR frame against a reference position,
+// picking the zone that puts it closest to the reference.
+func DecodeLocalCPR(rawLatitude uint32, rawLongitude uint32, isOddFrame bool, surface bool,
+	refLat float64, refLon float64) (latitude float64, longitude float64) {
+	const cprMax = 131072.0
+
+	zone := 360.0
+	if surface {
+		zone = 90.0
+	}
+
+	dlat := zone / 60.0
+	if isOddFrame {
+		dlat = zone / 59.0
+	}
+
+	latFraction := float64(rawLatitude) / cprMax
+	j := math.Floor(refLat/dlat) + math.Floor(0.5+positiveMod(refLat, dlat)/dlat-latFraction)
+	latitude = dlat * (j + latFraction)
+	if latitude < -90 || latitude > 90 {
+		return math.MaxFloat64, math.MaxFloat64
+	}
+
+	dlon := cprDlonFunction(latitude, isOddFrame, surface)
+	lonFraction := float64(rawLongitude) / cprMax
+	m := math.Floor(refLon/dlon) + math.Floor(0.5+positiveMod(refLon, dlon)/dlon-lonFraction)
+	longitude = dlon * (m + lonFraction)
+	longitude -= math.Floor((longitude+180.0)/360.0) * 360.0
+
+	return latitude, longitude
+}
@@ -0,0 +1,135 @@
+package modes
+
+import (
+	"sync/atomic"
+)
+
+// https://mode-s.org/decode/content/ads-b/8-error-control.html
+const modeSGenerator = 0xFFF409
+
+var crcTable = makeCRCTable()
+
+// Syndromes of every one and two bit error in a 112 bit extended squitter
+var crcErrorTable = makeErrorTable(112)
+
+func makeCRCTable() (table [256]uint32) {
+	for i := range table {
+		c := uint32(i) << 16
+		for j := 0; j < 8; j++ {
+			if c&0x800000 > 0 {
+				c = (c << 1) ^ modeSGenerator
+			} else {
+				c <<= 1
+			}
+		}
+		table[i] = c & 0xFFFFFF
+	}
+	return table
+}
+
+// modeSSyndrome XORs the CRC of the message with its parity field, so a clean
+// DF11/17/18 frame comes out as zero and an address/parity frame comes out as
+// the address it was overlaid with.
+func modeSSyndrome(message []byte) uint32 {
+	n := len(message) - 3
+
+	var crc uint32
+	for _, b := range message[:n] {
+		crc = ((crc << 8) ^ crcTable[b^byte(crc>>16)]) & 0xFFFFFF
+	}
+
+	parity := uint32(message[n])<<16 | uint32(message[n+1])<<8 | uint32(message[n+2])
+	return crc ^ parity
+}
+
+// makeErrorTable records which bits to flip for each syndrome caused by one
+// or two bit errors. The downlink format bits are left alone as they decide
+// the message length. Where a two bit error shares a syndrome with a single
+// bit error the single bit wins, any other collision is ambiguous and dropped.
+func makeErrorTable(msgBits int) map[uint32][]int {
+	table := make(map[uint32][]int)
+	ambiguous := make(map[uint32]bool)
+	message := make([]byte, msgBits/8)
+
+	add := func(bits ...int) {
+		for _, bit := range bits {
+			message[bit/8] ^= 0x80 >> uint(bit%8)
+		}
+		syndrome := modeSSyndrome(message)
+		for _, bit := range bits {
+			message[bit/8] ^= 0x80 >> uint(bit%8)
+		}
+
+		if existing, ok := table[syndrome]; ok {
+			if len(existing) == len(bits) {
+				ambiguous[syndrome] = true
+			}
+			return
+		}
+		table[syndrome] = bits
+	}
+
+	for i := 5; i < msgBits; i++ {
+		add(i)
+	}
+	for i := 5; i < msgBits; i++ {
+		for j := i + 1; j < msgBits; j++ {
+			add(i, j)
+		}
+	}
+
+	for syndrome := range ambiguous {
+		delete(table, syndrome)
+	}
+	return table
+}
+
+// checkCRC reports whether a frame can be trusted. DF17 frames with up to
+// FixErrors bad bits are repaired, returning a corrected copy. Formats whose
+// parity is overlaid with an address can't be checked here and are passed
+// through.
+func (decoder *Decoder) checkCRC(frame []byte, df uint8) ([]byte, bool) {
+	syndrome := modeSSyndrome(frame)
+
+	switch df {
+	case 11:
+		// The low 7 bits may carry the interrogator code
+		if syndrome&0xFFFF80 == 0 {
+			atomic.AddUint64(&decoder.stats.Good, 1)
+			return frame, true
+		}
+	case 17, 18:
+		if syndrome == 0 {
+			atomic.AddUint64(&decoder.stats.Good, 1)
+			return frame, true
+		}
+		if df == 17 {
+			if fixed, ok := fixModeSErrors(frame, syndrome, decoder.FixErrors); ok {
+				atomic.AddUint64(&decoder.stats.Corrected, 1)
+				return fixed, true
+			}
+		}
+	default:
+		return frame, true
+	}
+
+	atomic.AddUint64(&decoder.stats.Rejected, 1)
+	return frame, false
+}
+
+func fixModeSErrors(frame []byte, syndrome uint32, maxErrors int) ([]byte, bool) {
+	if len(frame) != 14 {
+		return frame, false
+	}
+
+	bits, ok := crcErrorTable[syndrome]
+	if !ok || len(bits) > maxErrors {
+		return frame, false
+	}
+
+	fixed := append([]byte(nil), frame...)
+	for _, bit := range bits {
+		fixed[bit/8] ^= 0x80 >> uint(bit%8)
+	}
+	return fixed, true
+}
@@ -0,0 +1,83 @@
+package modes
+
+import (
+	"reflect"
+	"testing"
+)
+
+func Test_modeSSyndrome(t *testing.T) {
+	tests := []struct {
+		message []byte
+		want    uint32
+	}{
+		{message: []byte{141, 64, 115, 119, 33, 52, 66, 112, 226, 8, 32, 34, 235, 246}, want: 0},
+		{message: []byte{93, 72, 64, 214, 248, 116, 15}, want: 0},
+		{message: []byte{93, 72, 64, 214, 248, 116, 10}, want: 5},
+	}
+
+	for _, tc := range tests {
+		got := modeSSyndrome(tc.message)
+		if got != tc.want {
+			t.Fatalf("expected: %06x, got: %06x", tc.want, got)
+		}
+	}
+}
+
+func flipBits(message []byte, bits ...int) []byte {
+	flipped := append([]byte(nil), message...)
+	for _, bit := range bits {
+		flipped[bit/8] ^= 0x80 >> uint(bit%8)
+	}
+	return flipped
+}
+
+func Test_checkCRC(t *testing.T) {
+	good := []byte{141, 64, 115, 119, 33, 52, 66, 112, 226, 8, 32, 34, 235, 246}
+
+	tests := []struct {
+		message   []byte
+		df        uint8
+		maxErrors int
+		valid     bool
+		repaired  []byte
+	}{
+		{message: good, df: 17, maxErrors: 0, valid: true, repaired: good},
+		{message: flipBits(good, 40), df: 17, maxErrors: 0, valid: false},
+		{message: flipBits(good, 40), df: 17, maxErrors: 1, valid: true, repaired: good},
+		{message: flipBits(good, 111), df: 17, maxErrors: 1, valid: true, repaired: good},
+		{message: flipBits(good, 12, 90), df: 17, maxErrors: 1, valid: false},
+		{message: flipBits(good, 12, 90), df: 17, maxErrors: 2, valid: true, repaired: good},
+		{message: flipBits(good, 3), df: 17, maxErrors: 2, valid: false},
+		{message: []byte{93, 72, 64, 214, 248, 116, 10}, df: 11, maxErrors: 0, valid: true},
+		{message: flipBits([]byte{93, 72, 64, 214, 248, 116, 15}, 30), df: 11, maxErrors: 1, valid: false},
+	}
+
+	for _, tc := range tests {
+		decoder := Decoder{FixErrors: tc.maxErrors}
+		original := append([]byte(nil), tc.message...)
+
+		fixed, valid := decoder.checkCRC(tc.message, tc.df)
+		if valid != tc.valid {
+			t.Fatalf("%v: expected valid %v, got %v", tc.message, tc.valid, valid)
+		}
+		if tc.repaired != nil && !reflect.DeepEqual(fixed, tc.repaired) {
+			t.Fatalf("expected: %v, got: %v", tc.repaired, fixed)
+		}
+		if !reflect.DeepEqual(tc.message, original) {
+			t.Fatalf("expected the frame to be left alone, got: %v", tc.message)
+		}
+	}
+}
+
+func Test_decoderStats(t *testing.T) {
+	good := []byte{141, 64, 115, 119, 33, 52, 66, 112, 226, 8, 32, 34, 235, 246}
+	decoder := Decoder{FixErrors: 1}
+
+	for _, frame := range [][]byte{good, flipBits(good, 50), flipBits(good, 50, 60)} {
+		decoder.Decode(frame)
+	}
+
+	if stats := decoder.Stats(); stats != (CRCStats{Good: 1, Corrected: 1, Rejected: 1}) {
+		t.Fatalf("expected one of each, got %+v", stats)
+	}
+}
@@ -0,0 +1,519 @@
+package modes
+
+import (
+	"math"
+)
+
+const (
+	aisCharset = "@ABCDEFGHIJKLMNOPQRSTUVWXYZ[\\]^_ !\"#$%&'()*+,-./0123456789:;<=>?"
+)
+
+// ExtendedSquitter is a DF17 or DF18 message of a type we don't decode any
+// further
+type ExtendedSquitter struct {
+	Header
+	TypeCode uint8
+	SubType  uint8
+}
+
+// Identification is an aircraft identification message, type codes 1 to 4
+type Identification struct {
+	Header
+	// Eight characters, padded with spaces. Empty if none was sent.
+	Callsign string
+	// Emitter category as set and number, e.g. 0xA5 for a heavy, 0 if not reported
+	Category uint8
+}
+
+// AirbornePosition is an airborne position message, type codes 9 to 18 and
+// 20 to 22
+type AirbornePosition struct {
+	Header
+	TypeCode uint8
+	CPR      CPR
+	// ft, barometric or, for type codes 20 to 22, GNSS height
+	Altitude     int32
+	AltitudeGNSS bool
+	// Version 2 only, ADS-B versions before that sent a single antenna flag in
+	// the same bit
+	NICSupplementB bool
+}
+
+// SurfacePosition is a surface position message, type codes 5 to 8
+type SurfacePosition struct {
+	Header
+	CPR         CPR
+	GroundSpeed float64 // kt
+	Track       float64 // degrees
+}
+
+// Velocity is an airborne velocity message, type code 19. Subtypes 1 and 2
+// give a ground speed and track, 3 and 4 an airspeed and heading.
+type Velocity struct {
+	Header
+	GroundSpeed  float64 // kt
+	Track        float64 // degrees
+	Airspeed     int32   // kt
+	AirspeedTrue bool
+	Heading      float64 // degrees
+	VertRate     int32   // ft/min
+	VertRateGNSS bool
+	// ft, positive when the GNSS altitude is above the barometric one
+	GNSSBaroDiff int32
+}
+
+// EmergencyStatus is an emergency/priority status message, type code 28
+// subtype 1
+type EmergencyStatus struct {
+	Header
+	Emergency uint8
+	Squawk    uint16
+}
+
+// TargetState is what the autopilot has been asked to do, type code 29.
+// Subtype 0 is the ADS-B version 1 layout and subtype 1 the version 2 one.
+type TargetState struct {
+	Header
+	// ADS-B version of the layout, 1 or 2
+	Version             uint8
+	SelectedAltitude    float64 // ft
+	SelectedAltitudeFMS bool    // set by the FMS rather than the MCP/FCU
+	BaroSetting         float64 // mb, version 2 only
+	SelectedHeading     float64 // degrees
+	// Version 1 only, the selected heading is a track
+	SelectedTrack bool
+
+	// Whether the autopilot modes below were sent
+	ModesValid   bool
+	Autopilot    bool
+	VNAV         bool
+	AltitudeHold bool
+	Approach     bool
+	LNAV         bool
+
+	// TCASKnown is false when the version doesn't say whether TCAS is working
+	TCASKnown       bool
+	TCASOperational bool
+}
+
+// OperationalStatus is what an aircraft reports about its own ADS-B
+// equipment, type code 31
+type OperationalStatus struct {
+	Header
+	Surface        bool
+	Version        uint8
+	NICSupplementA bool
+	NACp           uint8
+	SIL            uint8
+	// Version 2 only, probability is per sample rather than per hour
+	SILSupplement bool
+	// Version 2 airborne only
+	GVA uint8
+	// TCASKnown is false when the version doesn't say whether TCAS is working
+	TCASKnown       bool
+	TCASOperational bool
+	// Version 2 only
+	ES1090In bool
+	UATIn    bool
+}
+
+// meBits returns bits first to last of the 56 bit ME field of an extended squitter
+func meBits(message []byte, first uint, last uint) uint {
+	return getBits(message, 32+first, 32+last)
+}
+
+// DF18 control field values
+// https://mode-s.org/decode/content/ads-b/1-basics.html
+const (
+	cfADSB          = 0 // non-transponder ADS-B with an ICAO address
+	cfADSBOther     = 1 // non-transponder ADS-B with an anonymous address
+	cfTISBFine      = 2 // fine TIS-B, ICAO address unless the IMF bit is set
+	cfTISBCoarse    = 3 // coarse TIS-B, a layout of its own
+	cfTISBManage    = 4 // TIS-B and ADS-R management
+	cfTISBFineOther = 5 // fine TIS-B with a non-ICAO address
+	cfADSR          = 6 // ADS-R rebroadcast, ICAO address unless the IMF bit is set
+)
+
+// decodeDF18Header works out which address space the AA field of a DF18
+// frame belongs to and where it came from. It returns false for frames we
+// can't decode.
+func decodeDF18Header(message []byte, header Header) (Header, bool) {
+	switch message[0] & 7 {
+	case cfADSB:
+		header.Source = SourceADSB
+	case cfADSBOther:
+		header.Source, header.AddressType = SourceADSB, AddressAnonymous
+	case cfTISBFine:
+		header.Source = SourceTISB
+		if imfSet(message) {
+			header.AddressType = AddressNonICAO
+		}
+	case cfTISBFineOther:
+		header.Source, header.AddressType = SourceTISB, AddressNonICAO
+	case cfADSR:
+		header.Source = SourceADSR
+		if imfSet(message) {
+			header.AddressType = AddressNonICAO
+		}
+	default:
+		return header, false
+	}
+	return header, true
+}
+
+// imfSet reads the ICAO/Mode A flag TIS-B and ADS-R carry in place of a bit
+// ADS-B uses for something else. It is set when the AA field isn't an ICAO
+// address. Messages without one are taken to use ICAO addresses.
+func imfSet(message []byte) bool {
+	switch msgType := meBits(message, 1, 5); {
+	case msgType >= 5 && msgType <= 8:
+		return meBits(message, 21, 21) == 1
+	case msgType >= 9 && msgType <= 18, msgType >= 20 && msgType <= 22:
+		return meBits(message, 8, 8) == 1
+	case msgType == 19:
+		return meBits(message, 9, 9) == 1
+	}
+	return false
+}
+
+func decodeExtendedSquitter(message []byte, header Header) Message {
+	msgType := uint(message[4]) >> 3
+
+	var msgSubType uint
+	if msgType == 29 {
+		msgSubType = (uint(message[4]) & 6) >> 1
+	} else {
+		msgSubType = uint(message[4]) & 7
+	}
+
+	switch msgType {
+	case 1, 2, 3, 4:
+		// Aircraft ID
+		ident := &Identification{Header: header, Callsign: decodeCallsign(&message)}
+		if msgSubType != 0 {
+			// Type codes 4 down to 1 are category sets A to D
+			ident.Category = uint8(0xE-msgType)<<4 | uint8(msgSubType)
+		}
+		return ident
+
+	case 19:
+		// Airborne velocity
+		return decodeAirborneVelocity(message, msgSubType, header)
+
+	case 29:
+		// Target state and status
+		switch {
+		case msgSubType == 1:
+			return decodeTargetState(message, header)
+		case msgSubType == 0 && meBits(message, 11, 11) == 0:
+			// Bit 11 is always clear in version 1 target states
+			return decodeTargetStateV1(message, header)
+		}
+
+	case 31:
+		// Aircraft operational status, subtypes 0 and 1 are airborne and
+		// surface, anything else is reserved
+		if msgSubType <= 1 {
+			return decodeOperationalStatus(message, msgSubType, header)
+		}
+
+	case 28:
+		// Emergency/priority status
+		if msgSubType == 1 {
+			return &EmergencyStatus{
+				Header:    header,
+				Emergency: uint8(getBits(message, 41, 43)),
+				Squawk:    uint16(decodeID13Field(getBits(message, 44, 56))),
+			}
+		}
+
+	case 5, 6, 7, 8:
+		// Ground position
+		position := &SurfacePosition{Header: header, CPR: decodeCPR(message)}
+		position.GroundSpeed, position.Track = decodeSurfaceMovement(message)
+		return position
+
+	case 9, 10, 11, 12, 13, 14, 15, 16, 17, 18, 20, 21, 22:
+		// Airborne position
+		return decodeAirbornePosition(&message, msgType, header)
+	}
+
+	return &ExtendedSquitter{Header: header, TypeCode: uint8(msgType), SubType: uint8(msgSubType)}
+}
+
+func decodeCallsign(message *[]byte) string {
+	chars1 := uint((*message)[5])<<16 + uint((*message)[6])<<8 + uint((*message)[7])
+	chars2 := uint((*message)[8])<<16 + uint((*message)[9])<<8 + uint((*message)[10])
+
+	var fltByte [8]byte
+
+	if chars1 != 0 && chars2 != 0 {
+		// Flush the buffered raw bits into the representative 8 char string
+
+		fltByte[3] = aisCharset[chars1&0x3F]
+		chars1 >>= 6
+
+		fltByte[2] = aisCharset[chars1&0x3F]
+		chars1 >>= 6
+
+		fltByte[1] = aisCharset[chars1&0x3F]
+		chars1 >>= 6
+
+		fltByte[0] = aisCharset[chars1&0x3F]
+
+		fltByte[7] = aisCharset[chars2&0x3F]
+		chars2 >>= 6
+
+		fltByte[6] = aisCharset[chars2&0x3F]
+		chars2 >>= 6
+
+		fltByte[5] = aisCharset[chars2&0x3F]
+		chars2 >>= 6
+
+		fltByte[4] = aisCharset[chars2&0x3F]
+
+		return string(fltByte[:8])
+	}
+
+	return ""
+}
+
+// decodeCPR reads the raw position shared by airborne and surface position messages
+func decodeCPR(message []byte) CPR {
+	return CPR{
+		Lat: uint32(message[6])&3<<15 + uint32(message[7])<<7 +
+			uint32(message[8])>>1,
+		Lon: uint32(message[8])&1<<16 + uint32(message[9])<<8 +
+			uint32(message[10]),
+		Odd:   (message[6] & 4) == 4,
+		TFlag: (message[6] & 8) == 8,
+	}
+}
+
+func decodeAirbornePosition(message *[]byte, msgType uint, header Header) *AirbornePosition {
+	ac12Data := (uint((*message)[5]) << 4) + (uint((*message)[6])>>4)&0x0FFF
+
+	position := &AirbornePosition{Header: header, TypeCode: uint8(msgType), CPR: decodeCPR(*message)}
+	// TIS-B and ADS-R send the IMF in this bit instead
+	position.NICSupplementB = header.Source == SourceADSB && meBits(*message, 8, 8) == 1
+
+	if msgType != 20 && msgType != 21 && msgType != 22 {
+		position.Altitude = decodeAC12Field(ac12Data)
+	} else {
+		// GNSS height, reported in metres
+		position.Altitude = int32(math.Round(float64(ac12Data) * feetInMeter))
+		position.AltitudeGNSS = true
+	}
+
+	return position
+}
+
+func decodeAirborneVelocity(message []byte, msgSubType uint, header Header) *Velocity {
+	velocity := &Velocity{
+		Header:       header,
+		GroundSpeed:  math.MaxFloat64,
+		Track:        math.MaxFloat64,
+		Airspeed:     math.MaxInt32,
+		Heading:      math.MaxFloat64,
+		VertRate:     math.MaxInt32,
+		GNSSBaroDiff: math.MaxInt32,
+	}
+
+	// Subtypes 2 and 4 are supersonic and count in 4 knot steps
+	speedUnit := uint(1)
+	if msgSubType == 2 || msgSubType == 4 {
+		speedUnit = 4
+	}
+
+	switch msgSubType {
+	case 1, 2:
+		ewRaw := getBits(message, 47, 56)
+		nsRaw := getBits(message, 58, 67)
+		if ewRaw != 0 && nsRaw != 0 {
+			ewVel := float64((ewRaw - 1) * speedUnit)
+			if getBits(message, 46, 46) == 1 {
+				ewVel = -ewVel
+			}
+			nsVel := float64((nsRaw - 1) * speedUnit)
+			if getBits(message, 57, 57) == 1 {
+				nsVel = -nsVel
+			}
+
+			velocity.GroundSpeed = math.Hypot(ewVel, nsVel)
+			velocity.Track = math.Mod(math.Atan2(ewVel, nsVel)*180/math.Pi+360, 360)
+		}
+	case 3, 4:
+		if getBits(message, 46, 46) == 1 {
+			velocity.Heading = float64(getBits(message, 47, 56)) * 360 / 1024
+		}
+		airspeedRaw := getBits(message, 58, 67)
+		if airspeedRaw != 0 {
+			velocity.Airspeed = int32((airspeedRaw - 1) * speedUnit)
+			velocity.AirspeedTrue = getBits(message, 57, 57) == 1
+		}
+	default:
+		return velocity
+	}
+
+	vertRateRaw := getBits(message, 70, 78)
+	if vertRateRaw != 0 {
+		velocity.VertRate = int32(vertRateRaw-1) * 64
+		if getBits(message, 69, 69) == 1 {
+			velocity.VertRate = -velocity.VertRate
+		}
+		velocity.VertRateGNSS = getBits(message, 68, 68) == 0
+	}
+
+	diffRaw := getBits(message, 82, 88)
+	if diffRaw != 0 {
+		velocity.GNSSBaroDiff = int32(diffRaw-1) * 25
+		if getBits(message, 81, 81) == 1 {
+			velocity.GNSSBaroDiff = -velocity.GNSSBaroDiff
+		}
+	}
+
+	return velocity
+}
+
+// decodeSurfaceMovement reads the ground speed and track from a surface
+// position message.
+func decodeSurfaceMovement(message []byte) (groundSpeed float64, track float64) {
+	groundSpeed, track = math.MaxFloat64, math.MaxFloat64
+	movement := getBits(message, 38, 44)
+
+	// Ground speed is quantised more finely the slower the aircraft is going
+	// https://mode-s.org/decode/content/ads-b/5-surface-position.html
+	steps := []struct {
+		movement uint
+		knots    float64
+		step     float64
+	}{
+		{124, 175, 0},
+		{109, 100, 5},
+		{94, 70, 2},
+		{39, 15, 1},
+		{13, 2, 0.5},
+		{9, 1, 0.25},
+		{2, 0.125, 0.125},
+		{1, 0, 0},
+	}
+
+	if movement != 0 && movement <= 124 {
+		for _, s := range steps {
+			if movement >= s.movement {
+				groundSpeed = s.knots + float64(movement-s.movement)*s.step
+				break
+			}
+		}
+	}
+
+	if getBits(message, 45, 45) == 1 {
+		track = float64(getBits(message, 46, 52)) * 360 / 128
+	}
+
+	return groundSpeed, track
+}
+
+func decodeTargetState(message []byte, header Header) *TargetState {
+	state := &TargetState{
+		Header:           header,
+		Version:          2,
+		SelectedAltitude: math.MaxFloat64,
+		BaroSetting:      math.MaxFloat64,
+		SelectedHeading:  math.MaxFloat64,
+	}
+
+	altitudeRaw := meBits(message, 10, 20)
+	if altitudeRaw != 0 {
+		state.SelectedAltitude = float64((altitudeRaw - 1) * 32)
+		state.SelectedAltitudeFMS = meBits(message, 9, 9) == 1
+	}
+
+	baroRaw := meBits(message, 21, 29)
+	if baroRaw != 0 {
+		state.BaroSetting = float64(baroRaw-1)*0.8 + 800
+	}
+
+	if meBits(message, 30, 30) == 1 {
+		state.SelectedHeading = float64(meBits(message, 31, 39)) * 360 / 512
+	}
+
+	if meBits(message, 47, 47) == 1 {
+		state.ModesValid = true
+		state.Autopilot = meBits(message, 48, 48) == 1
+		state.VNAV = meBits(message, 49, 49) == 1
+		state.AltitudeHold = meBits(message, 50, 50) == 1
+		state.Approach = meBits(message, 52, 52) == 1
+		state.LNAV = meBits(message, 54, 54) == 1
+	}
+
+	state.TCASKnown = true
+	state.TCASOperational = meBits(message, 53, 53) == 1
+	return state
+}
+
+// decodeTargetStateV1 decodes the DO-260A layout, which has a target altitude
+// in 100 ft steps and a target heading or track but no baro setting or
+// autopilot modes
+func decodeTargetStateV1(message []byte, header Header) *TargetState {
+	state := &TargetState{
+		Header:           header,
+		Version:          1,
+		SelectedAltitude: math.MaxFloat64,
+		BaroSetting:      math.MaxFloat64,
+		SelectedHeading:  math.MaxFloat64,
+	}
+
+	// Where the target altitude comes from: none, the MCP/FCU, the altitude
+	// being held, or the FMS
+	if altitudeSource := meBits(message, 8, 9); altitudeSource != 0 {
+		state.SelectedAltitude = float64(int(meBits(message, 16, 25))*100 - 1000)
+		state.SelectedAltitudeFMS = altitudeSource == 3
+	}
+
+	if meBits(message, 26, 27) != 0 {
+		if heading := meBits(message, 28, 36); heading < 360 {
+			state.SelectedHeading = float64(heading)
+			state.SelectedTrack = meBits(message, 37, 37) == 1
+		}
+	}
+
+	return state
+}
+
+func decodeOperationalStatus(message []byte, msgSubType uint, header Header) *OperationalStatus {
+	status := &OperationalStatus{
+		Header:         header,
+		Surface:        msgSubType == 1,
+		Version:        uint8(meBits(message, 41, 43)),
+		NICSupplementA: meBits(message, 44, 44) == 1,
+		NACp:           uint8(meBits(message, 45, 48)),
+		SIL:            uint8(meBits(message, 51, 52)),
+	}
+
+	if msgSubType == 0 {
+		// Version 1 flags TCAS being unavailable, version 2 flags it working
+		switch status.Version {
+		case 1:
+			status.TCASKnown = true
+			status.TCASOperational = meBits(message, 11, 11) == 0
+		case 2:
+			status.TCASKnown = true
+			status.TCASOperational = meBits(message, 11, 11) == 1
+		}
+	}
+
+	if status.Version >= 2 {
+		// Bit 12 was CDTI in version 1
+		status.ES1090In = meBits(message, 12, 12) == 1
+		status.SILSupplement = meBits(message, 55, 55) == 1
+		if msgSubType == 0 {
+			status.GVA = uint8(meBits(message, 49, 50))
+			status.UATIn = meBits(message, 19, 19) == 1
+		} else {
+			status.UATIn = meBits(message, 16, 16) == 1
+		}
+	}
+
+	return status
+}
@@ -0,0 +1,283 @@
+package modes
+
+import (
+	"errors"
+	"math"
+	"testing"
+)
+
+func Test_decodeAirborneVelocity(t *testing.T) {
+	tests := []struct {
+		message      []byte
+		groundSpeed  float64
+		track        float64
+		airspeed     int32
+		airspeedTrue bool
+		heading      float64
+		vertRate     int32
+		vertRateGNSS bool
+		gnssBaroDiff int32
+	}{
+		{message: []byte{141, 72, 80, 32, 153, 68, 9, 148, 8, 56, 23, 91, 40, 79},
+			groundSpeed: 159.20, track: 182.88, airspeed: math.MaxInt32, heading: math.MaxFloat64,
+			vertRate: -832, vertRateGNSS: true, gnssBaroDiff: 550},
+		{message: []byte{141, 160, 95, 33, 155, 6, 182, 175, 24, 148, 0, 203, 195, 63},
+			groundSpeed: math.MaxFloat64, track: math.MaxFloat64, airspeed: 375, airspeedTrue: true, heading: 243.98,
+			vertRate: -2304, vertRateGNSS: false, gnssBaroDiff: math.MaxInt32},
+	}
+
+	for _, tc := range tests {
+		decoded, err := Decode(tc.message)
+		if err != nil {
+			t.Fatal(err)
+		}
+		velocity, ok := decoded.(*Velocity)
+		if !ok {
+			t.Fatalf("expected a velocity, got: %T", decoded)
+		}
+
+		if math.Abs(velocity.GroundSpeed-tc.groundSpeed) > 0.01 {
+			t.Fatalf("expected: %v, got: %v", tc.groundSpeed, velocity.GroundSpeed)
+		}
+		if math.Abs(velocity.Track-tc.track) > 0.01 {
+			t.Fatalf("expected: %v, got: %v", tc.track, velocity.Track)
+		}
+		if velocity.Airspeed != tc.airspeed || velocity.AirspeedTrue != tc.airspeedTrue {
+			t.Fatalf("expected: %v %v, got: %v %v", tc.airspeed, tc.airspeedTrue,
+				velocity.Airspeed, velocity.AirspeedTrue)
+		}
+		if math.Abs(velocity.Heading-tc.heading) > 0.01 {
+			t.Fatalf("expected: %v, got: %v", tc.heading, velocity.Heading)
+		}
+		if velocity.VertRate != tc.vertRate || velocity.VertRateGNSS != tc.vertRateGNSS {
+			t.Fatalf("expected: %v %v, got: %v %v", tc.vertRate, tc.vertRateGNSS,
+				velocity.VertRate, velocity.VertRateGNSS)
+		}
+		if velocity.GNSSBaroDiff != tc.gnssBaroDiff {
+			t.Fatalf("expected: %v, got: %v", tc.gnssBaroDiff, velocity.GNSSBaroDiff)
+		}
+	}
+}
+
+func Test_decodeIdentification(t *testing.T) {
+	tests := []struct {
+		message  []byte
+		callsign string
+		category uint8
+	}{
+		{message: []byte{141, 64, 115, 119, 33, 52, 66, 112, 226, 8, 32, 34, 235, 246}, callsign: "MDI08   ", category: 0xA1},
+		{message: []byte{141, 64, 115, 119, 37, 52, 66, 112, 226, 8, 32, 34, 235, 246}, callsign: "MDI08   ", category: 0xA5},
+		{message: []byte{141, 64, 115, 119, 25, 52, 66, 112, 226, 8, 32, 34, 235, 246}, callsign: "MDI08   ", category: 0xB1},
+		{message: []byte{141, 64, 115, 119, 32, 52, 66, 112, 226, 8, 32, 34, 235, 246}, callsign: "MDI08   ", category: 0},
+	}
+
+	for _, tc := range tests {
+		ident, ok := decodeExtendedSquitter(tc.message, Header{}).(*Identification)
+		if !ok {
+			t.Fatalf("expected an identification")
+		}
+		if ident.Callsign != tc.callsign {
+			t.Fatalf("expected: %q, got: %q", tc.callsign, ident.Callsign)
+		}
+		if ident.Category != tc.category {
+			t.Fatalf("expected: %02X, got: %02X", tc.category, ident.Category)
+		}
+	}
+}
+
+func Test_decodeSurfacePosition(t *testing.T) {
+	decoded, err := Decode([]byte{140, 72, 65, 117, 58, 154, 21, 50, 55, 174, 240, 242, 117, 190})
+	if err != nil {
+		t.Fatal(err)
+	}
+	position, ok := decoded.(*SurfacePosition)
+	if !ok {
+		t.Fatalf("expected a surface position, got: %T", decoded)
+	}
+
+	if position.GroundSpeed != 17 {
+		t.Fatalf("expected: 17, got: %v", position.GroundSpeed)
+	}
+	if position.Track != 92.8125 {
+		t.Fatalf("expected: 92.8125, got: %v", position.Track)
+	}
+	if position.CPR.Lat == math.MaxUint32 || position.CPR.Lon == math.MaxUint32 {
+		t.Fatalf("expected a raw position, got: %+v", position.CPR)
+	}
+}
+
+func Test_decodeAirbornePosition(t *testing.T) {
+	tests := []struct {
+		message  []byte
+		cpr      CPR
+		altitude int32
+	}{
+		{message: []byte{141, 64, 98, 29, 88, 195, 130, 214, 144, 200, 172, 40, 99, 167}, cpr: CPR{Lat: 93000, Lon: 51372}, altitude: 38000},
+		{message: []byte{141, 64, 98, 29, 88, 195, 134, 67, 92, 196, 18, 105, 42, 214}, cpr: CPR{Lat: 74158, Lon: 50194, Odd: true}, altitude: 38000},
+	}
+
+	for _, tc := range tests {
+		decoded, err := Decode(tc.message)
+		if err != nil {
+			t.Fatal(err)
+		}
+		position, ok := decoded.(*AirbornePosition)
+		if !ok {
+			t.Fatalf("expected an airborne position, got: %T", decoded)
+		}
+		if position.CPR != tc.cpr {
+			t.Fatalf("expected: %+v, got: %+v", tc.cpr, position.CPR)
+		}
+		if position.Altitude != tc.altitude || position.AltitudeGNSS {
+			t.Fatalf("expected: %v, got: %v", tc.altitude, position.Altitude)
+		}
+		if position.TypeCode != 11 || position.NICSupplementB {
+			t.Fatalf("expected type code 11 without NIC supplement B, got: %+v", position)
+		}
+	}
+}
+
+func Test_decodeTargetState(t *testing.T) {
+	decoded, err := Decode([]byte{141, 160, 86, 41, 234, 33, 72, 92, 191, 63, 140, 173, 174, 235})
+	if err != nil {
+		t.Fatal(err)
+	}
+	state, ok := decoded.(*TargetState)
+	if !ok {
+		t.Fatalf("expected a target state, got: %T", decoded)
+	}
+
+	if state.SelectedAltitude != 16992 || state.SelectedAltitudeFMS {
+		t.Fatalf("expected: 16992 MCP, got: %v FMS %v", state.SelectedAltitude, state.SelectedAltitudeFMS)
+	}
+	if math.Abs(state.BaroSetting-1012.8) > 0.01 {
+		t.Fatalf("expected: 1012.8, got: %v", state.BaroSetting)
+	}
+	if math.Abs(state.SelectedHeading-66.8) > 0.01 {
+		t.Fatalf("expected: 66.8, got: %v", state.SelectedHeading)
+	}
+	if !state.ModesValid || !state.Autopilot || !state.VNAV || state.AltitudeHold || state.Approach || !state.LNAV {
+		t.Fatalf("expected autopilot, VNAV and LNAV, got %+v", state)
+	}
+	if state.Version != 2 || !state.TCASKnown || !state.TCASOperational {
+		t.Fatalf("expected version 2 with TCAS operational, got: %+v", state)
+	}
+}
+
+func Test_decodeTargetStateV1(t *testing.T) {
+	// MCP target altitude 35000, target track 270, from DO-260A
+	message := []byte{141, 72, 64, 214, 232, 132, 180, 48, 233, 60, 0, 0, 0, 0}
+	state, ok := decodeExtendedSquitter(message, Header{}).(*TargetState)
+	if !ok {
+		t.Fatalf("expected a target state")
+	}
+
+	if state.Version != 1 || state.SelectedAltitude != 35000 || state.SelectedAltitudeFMS {
+		t.Fatalf("expected: version 1 35000 MCP, got: %+v", state)
+	}
+	if state.SelectedHeading != 270 || !state.SelectedTrack || state.BaroSetting != math.MaxFloat64 {
+		t.Fatalf("expected: track 270 without a baro setting, got: %+v", state)
+	}
+	if state.ModesValid || state.TCASKnown {
+		t.Fatalf("expected no autopilot modes or TCAS, got: %+v", state)
+	}
+
+	// The compatibility bit is never set in a version 1 target state
+	message[5] |= 0x20
+	if _, ok := decodeExtendedSquitter(message, Header{}).(*TargetState); ok {
+		t.Fatalf("expected no target state")
+	}
+}
+
+func Test_decodeOperationalStatus(t *testing.T) {
+	tests := []struct {
+		message        []byte
+		version        uint8
+		nicSupplementA bool
+		nacp           uint8
+		sil            uint8
+		gva            uint8
+		tcasKnown      bool
+		tcas           bool
+		es1090In       bool
+	}{
+		{message: []byte{141, 72, 64, 214, 248, 48, 0, 0, 0, 89, 184, 0, 0, 0},
+			version: 2, nicSupplementA: true, nacp: 9, sil: 3, gva: 2, tcasKnown: true, tcas: true, es1090In: true},
+		// Version 1 sets bit 11 when TCAS is not available
+		{message: []byte{141, 72, 64, 214, 248, 32, 0, 0, 0, 40, 32, 0, 0, 0},
+			version: 1, nicSupplementA: false, nacp: 8, sil: 2, gva: 0, tcasKnown: true, tcas: false, es1090In: false},
+		// Version 1 sets bit 12 for CDTI, not 1090ES IN
+		{message: []byte{141, 72, 64, 214, 248, 16, 0, 0, 0, 40, 32, 0, 0, 0},
+			version: 1, nicSupplementA: false, nacp: 8, sil: 2, gva: 0, tcasKnown: true, tcas: true, es1090In: false},
+		// Version 0 doesn't say
+		{message: []byte{141, 72, 64, 214, 248, 32, 0, 0, 0, 8, 32, 0, 0, 0},
+			version: 0, nicSupplementA: false, nacp: 8, sil: 2, gva: 0, tcasKnown: false, tcas: false, es1090In: false},
+	}
+
+	for _, tc := range tests {
+		status, ok := decodeExtendedSquitter(tc.message, Header{}).(*OperationalStatus)
+		if !ok {
+			t.Fatalf("expected an operational status")
+		}
+
+		if status.Version != tc.version {
+			t.Fatalf("expected: %v, got: %v", tc.version, status.Version)
+		}
+		if status.NICSupplementA != tc.nicSupplementA || status.NACp != tc.nacp || status.SIL != tc.sil || status.GVA != tc.gva {
+			t.Fatalf("expected: %v %v %v %v, got: %+v", tc.nicSupplementA, tc.nacp, tc.sil, tc.gva, status)
+		}
+		if status.TCASKnown != tc.tcasKnown || status.TCASOperational != tc.tcas || status.ES1090In != tc.es1090In {
+			t.Fatalf("expected TCAS %v/%v 1090ES IN %v, got: %+v", tc.tcasKnown, tc.tcas, tc.es1090In, status)
+		}
+	}
+}
+
+func Test_decodeEmergencyStatus(t *testing.T) {
+	decoded, err := Decode([]byte{141, 72, 64, 214, 225, 42, 170, 0, 0, 0, 0, 60, 245, 206})
+	if err != nil {
+		t.Fatal(err)
+	}
+	status, ok := decoded.(*EmergencyStatus)
+	if !ok {
+		t.Fatalf("expected an emergency status, got: %T", decoded)
+	}
+	if status.Emergency != 1 || status.Squawk != 0x7700 {
+		t.Fatalf("expected: 1 7700, got: %v %04x", status.Emergency, status.Squawk)
+	}
+}
+
+func Test_decodeDF18Header(t *testing.T) {
+	tests := []struct {
+		message     []byte
+		source      Source
+		addressType AddressType
+		err         error
+	}{
+		{message: []byte{144, 64, 98, 29, 88, 195, 130, 214, 144, 200, 172, 85, 111, 82}, source: SourceADSB, addressType: AddressICAO},
+		{message: []byte{145, 64, 98, 29, 88, 195, 130, 214, 144, 200, 172, 13, 30, 42}, source: SourceADSB, addressType: AddressAnonymous},
+		{message: []byte{146, 64, 98, 29, 88, 195, 130, 214, 144, 200, 172, 229, 141, 162}, source: SourceTISB, addressType: AddressICAO},
+		{message: []byte{146, 64, 98, 29, 89, 195, 130, 214, 144, 200, 172, 57, 247, 85}, source: SourceTISB, addressType: AddressNonICAO},
+		{message: []byte{147, 64, 98, 29, 88, 195, 130, 214, 144, 200, 172, 189, 252, 218}, err: ErrUnsupported},
+		{message: []byte{149, 64, 98, 29, 88, 195, 130, 214, 144, 200, 172, 147, 47, 195}, source: SourceTISB, addressType: AddressNonICAO},
+		{message: []byte{150, 64, 98, 29, 88, 195, 130, 214, 144, 200, 172, 123, 188, 75}, source: SourceADSR, addressType: AddressICAO},
+		{message: []byte{150, 64, 98, 29, 89, 195, 130, 214, 144, 200, 172, 167, 198, 188}, source: SourceADSR, addressType: AddressNonICAO},
+	}
+
+	for _, tc := range tests {
+		decoded, err := Decode(tc.message)
+		if !errors.Is(err, tc.err) || (err == nil) != (tc.err == nil) {
+			t.Fatalf("expected: %v, got: %v", tc.err, err)
+		}
+		if err != nil {
+			continue
+		}
+
+		header := decoded.MessageHeader()
+		if header.DF != 18 || header.Address != 0x40621D {
+			t.Fatalf("expected DF18 from 40621d, got: %+v", header)
+		}
+		if header.Source != tc.source || header.AddressType != tc.addressType {
+			t.Fatalf("expected: %v %v, got: %v %v", tc.source, tc.addressType, header.Source, header.AddressType)
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package modes
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+// FuzzDecode checks that no frame, however short or corrupt, can panic the
+// decoder, that anything turned down comes back as a FrameError and that
+// the frame is never modified.
+func FuzzDecode(f *testing.F) {
+	for _, seed := range [][]byte{
+		{},
+		{0x44, 0x10},
+		{93, 72, 64, 214, 248, 116, 15},
+		{32, 0, 24, 56, 89, 195, 141},
+		{40, 0, 10, 170, 2, 228, 31},
+		{141, 64, 115, 119, 33, 52, 66, 112, 226, 8, 32, 34, 235, 246},
+		{141, 64, 98, 29, 88, 195, 130, 214, 144, 200, 172, 40, 99, 167},
+		{140, 72, 65, 117, 58, 154, 21, 50, 55, 174, 240, 242, 117, 190},
+		{141, 72, 80, 32, 153, 68, 9, 148, 8, 56, 23, 91, 40, 79},
+		{141, 160, 86, 41, 234, 33, 72, 92, 191, 63, 140, 173, 174, 235},
+		{146, 64, 98, 29, 89, 195, 130, 214, 144, 200, 172, 57, 247, 85},
+		{160, 0, 8, 62, 32, 44, 195, 113, 195, 29, 224, 170, 28, 207},
+		{141, 64, 115, 119},
+	} {
+		f.Add(seed)
+	}
+
+	decoder := Decoder{FixErrors: 2}
+
+	f.Fuzz(func(t *testing.T, frame []byte) {
+		original := append([]byte(nil), frame...)
+
+		message, err := decoder.Decode(frame)
+		if err != nil {
+			var frameErr *FrameError
+			if !errors.As(err, &frameErr) {
+				t.Fatalf("expected a FrameError, got: %#v", err)
+			}
+			if message != nil {
+				t.Fatalf("expected no message alongside %v", err)
+			}
+		} else if message == nil {
+			t.Fatalf("expected a message or an error")
+		}
+
+		if !bytes.Equal(frame, original) {
+			t.Fatalf("frame was modified: %v became %v", original, frame)
+		}
+	})
+}
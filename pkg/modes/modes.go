@@ -0,0 +1,297 @@
+// Package modes decodes Mode S and Mode A/C replies into typed messages.
+//
+// Decoding is stateless, anything that needs more than one message, such as
+// pairing CPR frames or checking an address recovered from parity against
+// aircraft already seen, is left to the caller. Values a message doesn't
+// carry are set to the largest value of their type, math.MaxInt32 for an
+// unknown altitude, math.MaxFloat64 for an unknown speed and so on.
+package modes
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sync/atomic"
+)
+
+// Reasons Decode turns a frame down
+var (
+	ErrLength      = errors.New("modes: frame is not a valid length")
+	ErrCRC         = errors.New("modes: frame failed its parity check")
+	ErrUnsupported = errors.New("modes: frame format is not supported")
+)
+
+// FrameError is returned by Decode for a frame it can't use. It wraps one of
+// ErrLength, ErrCRC or ErrUnsupported, use errors.Is to tell which.
+type FrameError struct {
+	DF     uint8
+	Length int
+	Err    error
+}
+
+func (e *FrameError) Error() string {
+	return fmt.Sprintf("%v: DF%d, %d bytes", e.Err, e.DF, e.Length)
+}
+
+func (e *FrameError) Unwrap() error {
+	return e.Err
+}
+
+// FrameLength is how many bytes a Mode S frame of the given downlink format
+// should be. DF16 and above are 112 bit replies, everything below is 56 bit.
+func FrameLength(df uint8) int {
+	if df >= 16 {
+		return 14
+	}
+	return 7
+}
+
+// Source is where information about an aircraft comes from, in order of
+// increasing quality
+type Source uint8
+
+const (
+	SourceUnknown Source = iota
+	SourceModeAC
+	SourceModeS
+	SourceMLAT
+	SourceTISB
+	SourceADSR
+	SourceADSB
+)
+
+var sourceNames = []string{"", "Mode A/C", "Mode S", "MLAT", "TIS-B", "ADS-R", "ADS-B"}
+
+func (source Source) String() string {
+	if int(source) < len(sourceNames) {
+		return sourceNames[source]
+	}
+	return ""
+}
+
+// MarshalText lets the source appear by name in JSON
+func (source Source) MarshalText() ([]byte, error) {
+	return []byte(source.String()), nil
+}
+
+// UnmarshalText reads a source back from its name
+func (source *Source) UnmarshalText(text []byte) error {
+	for i, name := range sourceNames {
+		if name == string(text) {
+			*source = Source(i)
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown source %q", text)
+}
+
+// AddressType says how far the address of a message can be trusted and which
+// address space it belongs to
+type AddressType uint8
+
+const (
+	// A 24 bit ICAO address that passed a parity check
+	AddressICAO AddressType = iota
+	// An ICAO address recovered from the parity field. Any bit errors in the
+	// frame land in the address, so it should only be believed if it matches
+	// an aircraft that has recently sent a DF11, DF17 or DF18.
+	AddressParity
+	// An anonymous address from a non-transponder device, such as a ground
+	// vehicle or an obstacle
+	AddressAnonymous
+	// A TIS-B or ADS-R target the ground station knows by a track number
+	AddressNonICAO
+	// A Mode A/C reply, which carries no address. The Mode A code stands in.
+	AddressModeA
+)
+
+// Header is what every message carries
+type Header struct {
+	// Downlink format, only meaningful for Mode S
+	DF          uint8
+	Address     uint32
+	AddressType AddressType
+	Source      Source
+}
+
+// MessageHeader returns the header of a message
+func (header Header) MessageHeader() Header {
+	return header
+}
+
+// Message is one of the message types in this package, e.g. *Identification
+// or *AirbornePosition
+type Message interface {
+	MessageHeader() Header
+}
+
+// CRCStats counts frames by the result of their parity check
+type CRCStats struct {
+	Good      uint64
+	Corrected uint64
+	Rejected  uint64
+}
+
+// Decoder decodes frames and keeps count of how their parity checks went. It
+// is safe to use from several goroutines once set up.
+type Decoder struct {
+	// Number of bit errors to repair in DF17 frames: 0, 1 or 2
+	FixErrors int
+
+	stats CRCStats
+}
+
+var defaultDecoder = Decoder{FixErrors: 1}
+
+// Decode decodes a frame with a decoder that repairs single bit errors
+func Decode(frame []byte) (Message, error) {
+	return defaultDecoder.Decode(frame)
+}
+
+// Decode decodes a 2 byte Mode A/C reply or a 7 or 14 byte Mode S frame,
+// returning a *FrameError for anything it can't use. The frame isn't
+// modified, a repaired copy is decoded if it had bit errors.
+func (decoder *Decoder) Decode(frame []byte) (Message, error) {
+	if len(frame) == 2 {
+		return decodeModeAC(frame), nil
+	}
+	if len(frame) == 0 {
+		return nil, &FrameError{Err: ErrLength}
+	}
+
+	df := frame[0] >> 3
+	if len(frame) != FrameLength(df) {
+		return nil, &FrameError{DF: df, Length: len(frame), Err: ErrLength}
+	}
+
+	frame, ok := decoder.checkCRC(frame, df)
+	if !ok {
+		return nil, &FrameError{DF: df, Length: len(frame), Err: ErrCRC}
+	}
+
+	message, err := decodeModeS(frame, df)
+	if err != nil {
+		return nil, &FrameError{DF: df, Length: len(frame), Err: err}
+	}
+	return message, nil
+}
+
+// Stats returns the parity check counts so far
+func (decoder *Decoder) Stats() CRCStats {
+	return CRCStats{
+		Good:      atomic.LoadUint64(&decoder.stats.Good),
+		Corrected: atomic.LoadUint64(&decoder.stats.Corrected),
+		Rejected:  atomic.LoadUint64(&decoder.stats.Rejected),
+	}
+}
+
+// ModeAC is a Mode A/C reply. It may answer an identity or an altitude
+// interrogation and we can't tell which, so the code is given as the squawk
+// and, when it is also a valid Gillham code, as the altitude.
+type ModeAC struct {
+	Header
+	Squawk   uint16
+	Altitude int32
+}
+
+func decodeModeAC(frame []byte) *ModeAC {
+	// dump1090 lays the reply out as hex coded octal with SPI in 0x0080
+	modeA := (uint(frame[0])<<8 | uint(frame[1])) & 0x7777
+
+	reply := &ModeAC{
+		Header: Header{
+			Address:     uint32(modeA),
+			AddressType: AddressModeA,
+			Source:      SourceModeAC,
+		},
+		Squawk:   uint16(modeA),
+		Altitude: math.MaxInt32,
+	}
+	if modeC, ok := modeAToModeC(modeA); ok {
+		reply.Altitude = modeC * 100
+	}
+	return reply
+}
+
+// AllCall is a DF11 all-call reply
+type AllCall struct {
+	Header
+	Capability uint8
+}
+
+// AltitudeReply is a DF0, DF4, DF16 or DF20 reply. DF20 also carries a Comm-B
+// register, which is nil if we couldn't work out which one it is.
+type AltitudeReply struct {
+	Header
+	Altitude int32
+	CommB    *CommB
+}
+
+// IdentityReply is a DF5 or DF21 reply. DF21 also carries a Comm-B register,
+// which is nil if we couldn't work out which one it is.
+type IdentityReply struct {
+	Header
+	Squawk uint16
+	CommB  *CommB
+}
+
+func decodeModeS(frame []byte, df uint8) (Message, error) {
+	// https://en.wikipedia.org/wiki/Secondary_surveillance_radar#Mode_S
+	// https://github.com/mutability/dump1090/blob/master/mode_s.c
+	header := Header{
+		DF:          df,
+		Address:     uint32(frame[1])<<16 | uint32(frame[2])<<8 | uint32(frame[3]),
+		AddressType: AddressICAO,
+		Source:      SourceModeS,
+	}
+
+	switch df {
+	case 11:
+		return &AllCall{Header: header, Capability: frame[0] & 7}, nil
+
+	case 17:
+		header.Source = SourceADSB
+		return decodeExtendedSquitter(frame, header), nil
+
+	case 18:
+		var ok bool
+		if header, ok = decodeDF18Header(frame, header); !ok {
+			return nil, ErrUnsupported
+		}
+		return decodeExtendedSquitter(frame, header), nil
+
+	case 0, 4, 16, 20:
+		header.Address, header.AddressType = modeSSyndrome(frame), AddressParity
+
+		// Altitude: 13 bit signal
+		altCode := (uint(frame[2])*256 + uint(frame[3])) & 0x1FFF
+		reply := &AltitudeReply{Header: header, Altitude: decodeAC13Field(altCode)}
+		if df == 20 {
+			reply.CommB = decodeCommB(frame)
+		}
+		return reply, nil
+
+	case 5, 21:
+		header.Address, header.AddressType = modeSSyndrome(frame), AddressParity
+
+		// Identity: 13 bit squawk
+		idCode := (uint(frame[2])*256 + uint(frame[3])) & 0x1FFF
+		reply := &IdentityReply{Header: header, Squawk: uint16(decodeID13Field(idCode))}
+		if df == 21 {
+			reply.CommB = decodeCommB(frame)
+		}
+		return reply, nil
+	}
+
+	return nil, ErrUnsupported
+}
+
+// getBits returns bits first to last of a message, numbered from 1 as they
+// are in the Mode S documentation.
+func getBits(message []byte, first uint, last uint) uint {
+	var value uint
+	for bit := first - 1; bit < last; bit++ {
+		value = value<<1 | uint(message[bit/8]>>(7-bit%8))&1
+	}
+	return value
+}
@@ -0,0 +1,126 @@
+package modes
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"testing"
+)
+
+func TestDecode(t *testing.T) {
+	tests := []struct {
+		name        string
+		frame       []byte
+		err         error
+		df          uint8
+		address     uint32
+		addressType AddressType
+		source      Source
+	}{
+		{name: "empty", frame: []byte{}, err: ErrLength},
+		{name: "too short", frame: []byte{93, 72, 64}, err: ErrLength},
+		{name: "long frame for a short format", frame: []byte{93, 72, 64, 214, 248, 116, 15, 0, 0, 0, 0, 0, 0, 0}, err: ErrLength},
+		{name: "short frame for a long format", frame: []byte{141, 72, 64, 214, 248, 116, 15}, err: ErrLength},
+		{name: "bad parity", frame: []byte{93, 72, 64, 214, 248, 118, 15}, err: ErrCRC},
+		{name: "unsupported format", frame: []byte{192, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}, err: ErrUnsupported},
+		{name: "all-call", frame: []byte{93, 72, 64, 214, 248, 116, 15}, df: 11, address: 0x4840D6, addressType: AddressICAO, source: SourceModeS},
+		{name: "address/parity", frame: []byte{32, 0, 24, 56, 89, 195, 141}, df: 4, address: 0x4840D6, addressType: AddressParity, source: SourceModeS},
+		{name: "extended squitter", frame: []byte{141, 64, 98, 29, 88, 195, 130, 214, 144, 200, 172, 40, 99, 167}, df: 17, address: 0x40621D, addressType: AddressICAO, source: SourceADSB},
+		{name: "Mode A/C", frame: []byte{0x44, 0x10}, address: 0x4410, addressType: AddressModeA, source: SourceModeAC},
+	}
+
+	for _, tc := range tests {
+		decoded, err := Decode(tc.frame)
+		if !errors.Is(err, tc.err) || (err == nil) != (tc.err == nil) {
+			t.Fatalf("%s: expected: %v, got: %v", tc.name, tc.err, err)
+		}
+		if err != nil {
+			var frameErr *FrameError
+			if !errors.As(err, &frameErr) || frameErr.Length != len(tc.frame) {
+				t.Fatalf("%s: expected a FrameError for %d bytes, got: %#v", tc.name, len(tc.frame), err)
+			}
+			continue
+		}
+
+		header := decoded.MessageHeader()
+		want := Header{DF: tc.df, Address: tc.address, AddressType: tc.addressType, Source: tc.source}
+		if header != want {
+			t.Fatalf("%s: expected: %+v, got: %+v", tc.name, want, header)
+		}
+	}
+}
+
+func TestDecodeReplies(t *testing.T) {
+	decoded, err := Decode([]byte{32, 0, 24, 56, 89, 195, 141})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if altitude := decoded.(*AltitudeReply).Altitude; altitude != 38000 {
+		t.Fatalf("expected: 38000, got: %v", altitude)
+	}
+
+	decoded, err = Decode([]byte{40, 0, 10, 170, 2, 228, 31})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if squawk := decoded.(*IdentityReply).Squawk; squawk != 0x7700 {
+		t.Fatalf("expected: 7700, got: %04x", squawk)
+	}
+
+	tests := []struct {
+		frame    []byte
+		squawk   uint16
+		altitude int32
+	}{
+		{frame: []byte{0x77, 0x00}, squawk: 0x7700, altitude: math.MaxInt32},
+		{frame: []byte{0x44, 0x90}, squawk: 0x4410, altitude: 6200},
+	}
+
+	for _, tc := range tests {
+		decoded, err := Decode(tc.frame)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reply := decoded.(*ModeAC)
+		if reply.Squawk != tc.squawk || reply.Altitude != tc.altitude {
+			t.Fatalf("expected: %04x %v, got: %04x %v", tc.squawk, tc.altitude, reply.Squawk, reply.Altitude)
+		}
+	}
+}
+
+func TestDecodeLeavesFrameAlone(t *testing.T) {
+	frame := []byte{141, 64, 115, 119, 33, 52, 66, 112, 226, 8, 32, 34, 235, 246}
+	frame[6] ^= 0x10
+
+	decoded, err := Decode(frame)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if callsign := decoded.(*Identification).Callsign; callsign != "MDI08   " {
+		t.Fatalf("expected: MDI08, got: %q", callsign)
+	}
+	if frame[6] != 66^0x10 {
+		t.Fatalf("expected the frame to be left alone")
+	}
+}
+
+func TestSourceJSON(t *testing.T) {
+	got, err := json.Marshal(map[string]Source{"source": SourceTISB})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != `{"source":"TIS-B"}` {
+		t.Errorf("Expected %s got %s", `{"source":"TIS-B"}`, got)
+	}
+
+	var sources map[string]Source
+	if err = json.Unmarshal([]byte(`{"a":"ADS-B","b":""}`), &sources); err != nil {
+		t.Fatal(err)
+	}
+	if sources["a"] != SourceADSB || sources["b"] != SourceUnknown {
+		t.Errorf("Expected ADS-B and unknown got %v", sources)
+	}
+	if err = json.Unmarshal([]byte(`{"a":"radar"}`), &sources); err == nil {
+		t.Errorf("Expected an unknown source to be rejected")
+	}
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jsmithedin/overmyhouse/pkg/modes"
+)
+
+// SBS-1 BaseStation output is a CSV line per message, already decoded:
+//
+//	MSG,3,1,1,4840D6,1,2024/01/01,12:00:00.000,2024/01/01,12:00:00.000,,38000,,,55.9,-3.2,,,0,0,0,0
+//
+// https://web.archive.org/web/20190719181538/http://woodair.net/sbs/article/barebones42_socket_data.htm
+const (
+	sbsType = iota + 1
+	_
+	_
+	sbsHexIdent
+	_
+	_
+	_
+	_
+	_
+	sbsCallsign
+	sbsAltitude
+	sbsGroundSpeed
+	sbsTrack
+	sbsLatitude
+	sbsLongitude
+	sbsVertRate
+	sbsSquawk
+	_
+	_
+	_
+	sbsOnGround
+	sbsFields
+)
+
+// Long enough for any record, anything longer is garbage
+const sbsMaxLine = 512
+
+var errSBSRecord = errors.New("not an SBS MSG record")
+
+// sbsMessage is one MSG record. Fields it doesn't carry are set to the
+// largest value of their type as they are in aircraftData.
+type sbsMessage struct {
+	msgType     int
+	icaoAddr    uint32
+	callsign    string
+	altitude    int32
+	groundSpeed float64
+	track       float64
+	latitude    float64
+	longitude   float64
+	vertRate    int32
+	squawk      uint16
+	onGround    bool
+	// Whether onGround was given
+	groundKnown bool
+}
+
+// parseSBSLine parses a MSG record. Other record types, such as SEL or ID,
+// and anything that doesn't parse return an error.
+func parseSBSLine(line string) (sbsMessage, error) {
+	fields := strings.Split(line, ",")
+	if len(fields) < sbsFields-1 || fields[0] != "MSG" {
+		return sbsMessage{}, errSBSRecord
+	}
+	for len(fields) < sbsFields {
+		fields = append(fields, "")
+	}
+	for i := range fields {
+		fields[i] = strings.TrimSpace(fields[i])
+	}
+
+	message := sbsMessage{
+		altitude:    math.MaxInt32,
+		groundSpeed: math.MaxFloat64,
+		track:       math.MaxFloat64,
+		latitude:    math.MaxFloat64,
+		longitude:   math.MaxFloat64,
+		vertRate:    math.MaxInt32,
+		squawk:      math.MaxUint16,
+	}
+
+	msgType, err := strconv.Atoi(fields[sbsType])
+	if err != nil || msgType < 1 || msgType > 8 {
+		return sbsMessage{}, fmt.Errorf("%w: transmission type %q", errSBSRecord, fields[sbsType])
+	}
+	message.msgType = msgType
+
+	icaoAddr, ok := parseHexIdent(fields[sbsHexIdent])
+	if !ok {
+		return sbsMessage{}, fmt.Errorf("%w: address %q", errSBSRecord, fields[sbsHexIdent])
+	}
+	message.icaoAddr = icaoAddr
+	message.callsign = padCallsign(fields[sbsCallsign])
+
+	var bad []string
+	if field := fields[sbsAltitude]; field != "" {
+		if altitude, err := strconv.ParseInt(field, 10, 32); err == nil {
+			message.altitude = int32(altitude)
+		} else {
+			bad = append(bad, "altitude")
+		}
+	}
+	if field := fields[sbsGroundSpeed]; field != "" {
+		if message.groundSpeed, err = strconv.ParseFloat(field, 64); err != nil {
+			message.groundSpeed = math.MaxFloat64
+			bad = append(bad, "ground speed")
+		}
+	}
+	if field := fields[sbsTrack]; field != "" {
+		if message.track, err = strconv.ParseFloat(field, 64); err != nil {
+			message.track = math.MaxFloat64
+			bad = append(bad, "track")
+		}
+	}
+	if fields[sbsLatitude] != "" && fields[sbsLongitude] != "" {
+		latitude, latErr := strconv.ParseFloat(fields[sbsLatitude], 64)
+		longitude, lonErr := strconv.ParseFloat(fields[sbsLongitude], 64)
+		if latErr == nil && lonErr == nil && math.Abs(latitude) <= 90 && math.Abs(longitude) <= 180 {
+			message.latitude, message.longitude = latitude, longitude
+		} else {
+			bad = append(bad, "position")
+		}
+	}
+	if field := fields[sbsVertRate]; field != "" {
+		if vertRate, err := strconv.ParseInt(field, 10, 32); err == nil {
+			message.vertRate = int32(vertRate)
+		} else {
+			bad = append(bad, "vertical rate")
+		}
+	}
+	if field := fields[sbsSquawk]; field != "" {
+		if squawk, ok := parseSquawk(field); ok {
+			message.squawk = squawk
+		} else {
+			bad = append(bad, "squawk")
+		}
+	}
+	if field := fields[sbsOnGround]; field != "" {
+		message.onGround = field != "0"
+		message.groundKnown = true
+	}
+
+	if len(bad) > 0 {
+		return sbsMessage{}, fmt.Errorf("%w: bad %s", errSBSRecord, strings.Join(bad, ", "))
+	}
+	return message, nil
+}
+
+// trackSBS updates the aircraft a record is about, returning how far away it
+// is if the record moved it, otherwise math.MaxFloat64
+func trackSBS(message sbsMessage, knownAircraft *KnownAircraft) (distance float64) {
+	distance = math.MaxFloat64
+
+	knownAircraft.Update(message.icaoAddr, func(aircraft *aircraftData) {
+		now := time.Now()
+		aircraft.lastPing = now
+		aircraft.mlat = false
+		// Nothing says where the receiver got it from
+		aircraft.setSource(modes.SourceUnknown, now)
+		if message.msgType <= 4 || message.msgType == 8 {
+			// Extended squitters and all-call replies
+			aircraft.lastSquitter = now
+		}
+
+		if message.callsign != "" {
+			aircraft.callsign = message.callsign
+		}
+		if message.altitude != math.MaxInt32 {
+			aircraft.altitude = message.altitude
+		}
+		if message.groundSpeed != math.MaxFloat64 {
+			aircraft.groundSpeed = message.groundSpeed
+		}
+		if message.track != math.MaxFloat64 {
+			aircraft.track = message.track
+		}
+		if message.vertRate != math.MaxInt32 {
+			aircraft.vertRate = message.vertRate
+		}
+		if message.squawk != math.MaxUint16 {
+			aircraft.squawk = message.squawk
+		}
+		if message.groundKnown {
+			aircraft.onGround = message.onGround
+		}
+
+		if message.latitude != math.MaxFloat64 && message.longitude != math.MaxFloat64 &&
+			acceptPosition(aircraft, message.latitude, message.longitude, now) {
+			distance = aircraft.distance
+		}
+	})
+	return distance
+}
+
+// handleSBSConnection tracks the records from an SBS connection until it
+// closes. Records are already decoded so they skip the Mode S decoder and the
+// duplicate check, a record heard by two receivers just updates the aircraft
+// twice.
+func handleSBSConnection(conn io.Reader, source *feedSource, knownAircraft *KnownAircraft) beastStats {
+	r := bufio.NewReaderSize(conn, sbsMaxLine)
+	var stats beastStats
+
+	for {
+		line, err := readLine(r, &stats)
+		if err != nil {
+			return stats
+		}
+
+		message, err := parseSBSLine(string(line))
+		if err != nil {
+			if strings.HasPrefix(string(line), "MSG") {
+				stats.malformed++
+			} else {
+				// SEL, ID, AIR, STA and CLK records say nothing we need
+				stats.dropped++
+			}
+			continue
+		}
+
+		stats.frames++
+		source.countFrame()
+		if distance := trackSBS(message, knownAircraft); distance != math.MaxFloat64 {
+			source.countPosition(distance)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_parseSBSLine(t *testing.T) {
+	tests := []struct {
+		name    string
+		line    string
+		want    sbsMessage
+		wantErr bool
+	}{
+		{
+			name: "identification",
+			line: "MSG,1,1,1,4840D6,1,2024/01/01,12:00:00.000,2024/01/01,12:00:00.000,KLM1023,,,,,,,,,,,0",
+			want: sbsMessage{msgType: 1, icaoAddr: 0x4840D6, callsign: "KLM1023 ", altitude: math.MaxInt32,
+				groundSpeed: math.MaxFloat64, track: math.MaxFloat64, latitude: math.MaxFloat64,
+				longitude: math.MaxFloat64, vertRate: math.MaxInt32, squawk: math.MaxUint16, groundKnown: true},
+		},
+		{
+			name: "airborne position",
+			line: "MSG,3,1,1,4840D6,1,2024/01/01,12:00:00.000,2024/01/01,12:00:00.000,,38000,,,55.95,-3.3,,,0,0,0,0",
+			want: sbsMessage{msgType: 3, icaoAddr: 0x4840D6, altitude: 38000, groundSpeed: math.MaxFloat64,
+				track: math.MaxFloat64, latitude: 55.95, longitude: -3.3, vertRate: math.MaxInt32,
+				squawk: math.MaxUint16, groundKnown: true},
+		},
+		{
+			name: "velocity without the on ground field",
+			line: "MSG,4,1,1,4840D6,1,2024/01/01,12:00:00.000,2024/01/01,12:00:00.000,,,420.5,91.2,,,-640,,,,",
+			want: sbsMessage{msgType: 4, icaoAddr: 0x4840D6, altitude: math.MaxInt32, groundSpeed: 420.5,
+				track: 91.2, latitude: math.MaxFloat64, longitude: math.MaxFloat64, vertRate: -640,
+				squawk: math.MaxUint16},
+		},
+		{
+			name: "surveillance squawk from a non ICAO address",
+			line: "MSG,6,1,1,~4840D6,1,2024/01/01,12:00:00.000,2024/01/01,12:00:00.000,,,,,,,,7700,0,1,0,-1",
+			want: sbsMessage{msgType: 6, icaoAddr: 0x4840D6 | nonICAOAddrFlag, altitude: math.MaxInt32,
+				groundSpeed: math.MaxFloat64, track: math.MaxFloat64, latitude: math.MaxFloat64,
+				longitude: math.MaxFloat64, vertRate: math.MaxInt32, squawk: 0x7700, onGround: true, groundKnown: true},
+		},
+		{name: "selection change", line: "SEL,,496,2286,4CA4E5,27215,2010/02/19,18:06:07.710,2010/02/19,18:06:07.710,RYR1427", wantErr: true},
+		{name: "short", line: "MSG,3,1,1,4840D6", wantErr: true},
+		{name: "bad type", line: "MSG,9,1,1,4840D6,1,,,,,,,,,,,,,,,,0", wantErr: true},
+		{name: "bad address", line: "MSG,3,1,1,48Z0D6,1,,,,,,,,,,,,,,,,0", wantErr: true},
+		{name: "bad squawk", line: "MSG,6,1,1,4840D6,1,,,,,,,,,,,,7800,,,,0", wantErr: true},
+		{name: "bad position", line: "MSG,3,1,1,4840D6,1,,,,,,38000,,,95.1,-3.3,,,,,,0", wantErr: true},
+	}
+
+	for _, tc := range tests {
+		got, err := parseSBSLine(tc.line)
+		if tc.wantErr {
+			if !errors.Is(err, errSBSRecord) {
+				t.Fatalf("%s: expected: %v, got: %v", tc.name, errSBSRecord, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		if got != tc.want {
+			t.Fatalf("%s: expected: %+v, got: %+v", tc.name, tc.want, got)
+		}
+	}
+}
+
+func Test_handleSBSConnection(t *testing.T) {
+	testKnownAircraft := &KnownAircraft{}
+	source := &feedSource{name: "club", format: formatSBS}
+
+	stream := strings.Join([]string{
+		"MSG,1,1,1,4840D6,1,2024/01/01,12:00:00.000,2024/01/01,12:00:00.000,KLM1023,,,,,,,,,,,0",
+		"MSG,3,1,1,4840D6,1,2024/01/01,12:00:00.000,2024/01/01,12:00:00.000,,38000,,,55.95,-3.3,,,0,0,0,0",
+		"MSG,4,1,1,4840D6,1,2024/01/01,12:00:00.000,2024/01/01,12:00:00.000,,,420,91,,,-640,,,,,0",
+		"",
+		"STA,,5,179,400AE7,10103,2008/11/28,14:58:51.153,2008/11/28,14:58:51.153,RM",
+		"MSG,3,1,1,4840D6,1,2024/01/01,12:00:00.000,2024/01/01,12:00:00.000,,38000,,,not,-3.3,,,0,0,0,0",
+		"MSG,5,1,1,4840D6,1,2024/01/01,12:00:00.000,2024/01/01,12:00:00.000,,37975,,,,,,,0,,0,0",
+	}, "\r\n")
+
+	stats := handleSBSConnection(strings.NewReader(stream), source, testKnownAircraft)
+	if stats.frames != 4 || stats.dropped != 1 || stats.malformed != 1 {
+		t.Fatalf("expected 4 records, 1 skipped and 1 malformed, got: %+v", stats)
+	}
+	if source.frames != 4 || source.positions != 1 {
+		t.Fatalf("expected 4 frames and 1 position, got: %v %v", source.frames, source.positions)
+	}
+
+	aircraft, known := testKnownAircraft.getAircraft(0x4840D6)
+	if !known {
+		t.Fatalf("expected 4840D6 to be known")
+	}
+	if aircraft.callsign != "KLM1023 " || aircraft.altitude != 37975 || aircraft.groundSpeed != 420 ||
+		aircraft.track != 91 || aircraft.vertRate != -640 {
+		t.Fatalf("expected KLM1023 at 37975 ft doing 420 kt on 91, got: %+v", aircraft)
+	}
+	if aircraft.latitude != 55.95 || aircraft.longitude != -3.3 || aircraft.distance == math.MaxFloat64 {
+		t.Fatalf("expected a position, got: %v %v %v", aircraft.latitude, aircraft.longitude, aircraft.distance)
+	}
+	if trail := testKnownAircraft.Trail(0x4840D6, time.Time{}); len(trail) != 1 {
+		t.Fatalf("expected: 1, got: %+v", trail)
+	}
+}
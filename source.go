@@ -0,0 +1,193 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// feedSource is one of the receivers we are fed from, with counters so the
+// antennas can be compared
+type feedSource struct {
+	name string
+	// Comma separated feeders for this receiver, the primary first
+	feeders string
+	// Wire format the feeders send, formatBeast, formatAVR or formatSBS, or
+	// formatJSON if the feeders are aircraft.json URLs to poll
+	format string
+
+	frames     uint64
+	duplicates uint64
+	positions  uint64
+	// Furthest position decoded, as the bits of a float64 in meters
+	maxRange uint64
+
+	// Frame count at the last report, only touched by the reporter
+	lastFrames uint64
+	lastReport time.Time
+}
+
+// Wire formats a source can send
+const (
+	formatBeast = "beast"
+	formatAVR   = "avr"
+	// Decoded SBS-1 BaseStation records rather than frames, see handleSBSConnection
+	formatSBS = "sbs"
+	// An aircraft.json list polled over HTTP, see jsonPoller
+	formatJSON = "json"
+)
+
+// newFrameReader reads frames from a stream in the source's format
+func (source *feedSource) newFrameReader(r io.Reader) frameReader {
+	if source.format == formatAVR {
+		return newAVRReader(r)
+	}
+	return newBeastReader(r)
+}
+
+func validFormat(format string) bool {
+	return format == formatBeast || format == formatAVR || format == formatSBS || format == formatJSON
+}
+
+// countFrame counts a frame read from the source
+func (source *feedSource) countFrame() {
+	atomic.AddUint64(&source.frames, 1)
+}
+
+// countDuplicate counts a frame another source got to first
+func (source *feedSource) countDuplicate() {
+	atomic.AddUint64(&source.duplicates, 1)
+}
+
+// countPosition counts a position decoded from the source, distance meters
+// from the receiver
+func (source *feedSource) countPosition(distance float64) {
+	atomic.AddUint64(&source.positions, 1)
+	for {
+		current := atomic.LoadUint64(&source.maxRange)
+		if distance <= math.Float64frombits(current) ||
+			atomic.CompareAndSwapUint64(&source.maxRange, current, math.Float64bits(distance)) {
+			return
+		}
+	}
+}
+
+// report describes the source's counters and its frame rate since the last report
+func (source *feedSource) report(now time.Time) string {
+	frames := atomic.LoadUint64(&source.frames)
+
+	var rate float64
+	if !source.lastReport.IsZero() {
+		rate = float64(frames-source.lastFrames) / now.Sub(source.lastReport).Seconds()
+	}
+	source.lastFrames, source.lastReport = frames, now
+
+	return fmt.Sprintf("%s: frames: %d\t%.1f/s\tduplicates: %d\tpositions: %d\tmax range: %3.2f miles",
+		source.name, frames, rate, atomic.LoadUint64(&source.duplicates), atomic.LoadUint64(&source.positions),
+		metersInMiles(math.Float64frombits(atomic.LoadUint64(&source.maxRange))))
+}
+
+// sourceFlags collects -source flags, each a name, optionally the format it
+// sends, and its feeders, e.g. roof=192.168.1.50:30005,192.168.1.51:30005 or
+// friend:avr=10.0.0.5:30002 or club:sbs=10.0.0.7:30003 or
+// tar1090:json=http://10.0.0.8/tar1090/data/aircraft.json
+type sourceFlags []*feedSource
+
+func (sources *sourceFlags) String() string {
+	var names []string
+	for _, source := range *sources {
+		names = append(names, source.name+":"+source.format+"="+source.feeders)
+	}
+	return strings.Join(names, " ")
+}
+
+func (sources *sourceFlags) Set(value string) error {
+	parts := strings.SplitN(value, "=", 2)
+	if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" || strings.TrimSpace(parts[1]) == "" {
+		return fmt.Errorf("expected name[:format]=host:port[,host:port...], got %q", value)
+	}
+
+	name, format := strings.TrimSpace(parts[0]), formatBeast
+	if i := strings.LastIndex(name, ":"); i >= 0 {
+		name, format = strings.TrimSpace(name[:i]), strings.ToLower(strings.TrimSpace(name[i+1:]))
+	}
+	if !validFormat(format) {
+		return fmt.Errorf("unknown format %q, expected %s, %s, %s or %s", format, formatBeast, formatAVR, formatSBS,
+			formatJSON)
+	}
+	if name == "" {
+		return fmt.Errorf("expected a name for %q", value)
+	}
+
+	for _, source := range *sources {
+		if source.name == name {
+			return fmt.Errorf("source %q given twice", name)
+		}
+	}
+	*sources = append(*sources, &feedSource{name: name, feeders: strings.TrimSpace(parts[1]), format: format})
+	return nil
+}
+
+// How long after one receiver hears a frame the same frame from another is
+// taken to be the same transmission
+const duplicateWindow = time.Second
+
+// frameDeduper spots frames heard by more than one receiver. Frames are
+// remembered in two generations that swap every window, so a frame is
+// remembered for at least one window and at most two without any per-frame
+// expiry.
+type frameDeduper struct {
+	window time.Duration
+
+	mu       sync.Mutex
+	current  map[string]*feedSource
+	previous map[string]*feedSource
+	started  time.Time
+}
+
+func newFrameDeduper(window time.Duration) *frameDeduper {
+	return &frameDeduper{
+		window:   window,
+		current:  make(map[string]*feedSource),
+		previous: make(map[string]*feedSource),
+	}
+}
+
+// duplicate checks whether a different source has sent the same frame
+// recently, remembering it if not. A receiver repeating a frame isn't a
+// duplicate, the aircraft really did send it twice.
+func (dedup *frameDeduper) duplicate(frame []byte, source *feedSource, now time.Time) bool {
+	dedup.mu.Lock()
+	defer dedup.mu.Unlock()
+
+	if age := now.Sub(dedup.started); age > dedup.window {
+		dedup.previous, dedup.current = dedup.current, dedup.previous
+		for key := range dedup.current {
+			delete(dedup.current, key)
+		}
+		if age > 2*dedup.window {
+			for key := range dedup.previous {
+				delete(dedup.previous, key)
+			}
+		}
+		dedup.started = now
+	}
+
+	first, seen := dedup.current[string(frame)]
+	if !seen {
+		first, seen = dedup.previous[string(frame)]
+	}
+	if seen && first != source {
+		return true
+	}
+
+	dedup.current[string(frame)] = source
+	return false
+}
+
+// Frames heard by more than one receiver are only tracked once
+var receivedFrames = newFrameDeduper(duplicateWindow)
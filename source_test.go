@@ -0,0 +1,100 @@
+package main
+
+import (
+	"math"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func Test_frameDeduper(t *testing.T) {
+	roof, shed := &feedSource{name: "roof"}, &feedSource{name: "shed"}
+	frame := []byte{93, 72, 64, 214, 248, 116, 15}
+	dedup := newFrameDeduper(time.Second)
+	now := time.Now()
+
+	tests := []struct {
+		name      string
+		source    *feedSource
+		at        time.Duration
+		duplicate bool
+	}{
+		{name: "first", source: roof, at: 0, duplicate: false},
+		{name: "other antenna", source: shed, at: 10 * time.Millisecond, duplicate: true},
+		{name: "same antenna again", source: roof, at: 20 * time.Millisecond, duplicate: false},
+		{name: "other antenna a window later", source: shed, at: 1500 * time.Millisecond, duplicate: true},
+		{name: "other antenna long after", source: shed, at: 5 * time.Second, duplicate: false},
+		{name: "first antenna after that", source: roof, at: 5100 * time.Millisecond, duplicate: true},
+	}
+
+	for _, tc := range tests {
+		if got := dedup.duplicate(frame, tc.source, now.Add(tc.at)); got != tc.duplicate {
+			t.Fatalf("%s: expected: %v, got: %v", tc.name, tc.duplicate, got)
+		}
+	}
+
+	if dedup.duplicate([]byte{0x44, 0x10}, shed, now.Add(5200*time.Millisecond)) {
+		t.Fatalf("expected a different frame not to be a duplicate")
+	}
+}
+
+func Test_sourceFlags(t *testing.T) {
+	var sources sourceFlags
+
+	if err := sources.Set("roof=192.168.1.50:30005,192.168.1.51:30005"); err != nil {
+		t.Fatal(err)
+	}
+	if err := sources.Set(" shed = 192.168.1.60:30005 "); err != nil {
+		t.Fatal(err)
+	}
+	if err := sources.Set("friend:AVR=10.0.0.5:30002"); err != nil {
+		t.Fatal(err)
+	}
+	if err := sources.Set("club:sbs=10.0.0.7:30003"); err != nil {
+		t.Fatal(err)
+	}
+	for _, value := range []string{"192.168.1.50:30005", "=192.168.1.50:30005", "roof=", "roof=192.168.1.52:30005",
+		"other:raw=10.0.0.6:30003", ":avr=10.0.0.6:30002"} {
+		if err := sources.Set(value); err == nil {
+			t.Fatalf("expected %q to be rejected", value)
+		}
+	}
+
+	if len(sources) != 4 || sources[1].name != "shed" || sources[1].feeders != "192.168.1.60:30005" {
+		t.Fatalf("expected roof, shed, friend and club, got: %v", sources.String())
+	}
+	if sources[0].format != formatBeast || sources[2].name != "friend" || sources[2].format != formatAVR ||
+		sources[3].format != formatSBS {
+		t.Fatalf("expected BEAST from roof, AVR from friend and SBS from club, got: %v", sources.String())
+	}
+}
+
+func Test_feedSourceCounters(t *testing.T) {
+	source := &feedSource{name: "roof"}
+
+	wg := sync.WaitGroup{}
+	for i := 1; i <= 100; i++ {
+		wg.Add(1)
+		go func(distance float64) {
+			defer wg.Done()
+			source.countFrame()
+			source.countPosition(distance)
+		}(float64(i) * 1000)
+	}
+	wg.Wait()
+
+	if source.positions != 100 || math.Float64frombits(source.maxRange) != 100000 {
+		t.Fatalf("expected 100 positions out to 100 km, got: %v %v", source.positions, math.Float64frombits(source.maxRange))
+	}
+
+	now := time.Now()
+	source.report(now)
+	for i := 0; i < 50; i++ {
+		source.countFrame()
+	}
+	report := source.report(now.Add(10 * time.Second))
+	if !strings.Contains(report, "frames: 150\t5.0/s") || !strings.Contains(report, "max range: 62.14 miles") {
+		t.Fatalf("unexpected report: %s", report)
+	}
+}
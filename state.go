@@ -0,0 +1,213 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/jsmithedin/overmyhouse/pkg/modes"
+)
+
+// Bumped whenever the layout of the state file changes, older files are ignored
+const stateVersion = 2
+
+// trackerState is what we keep on disk so a restart doesn't forget what we
+// were tracking and announce everything overhead again
+type trackerState struct {
+	Version     int             `json:"version"`
+	Saved       time.Time       `json:"saved"`
+	Aircraft    []savedAircraft `json:"aircraft"`
+	Tweeted     tweetedMap      `json:"tweeted"`
+	Emergencies tweetedMap      `json:"emergencies"`
+}
+
+// savedAircraft is the part of an aircraft worth keeping. Raw CPR halves and
+// Comm-B registers go stale within seconds so are left out.
+type savedAircraft struct {
+	ICAOAddr     uint32       `json:"icao"`
+	Callsign     string       `json:"callsign"`
+	Latitude     float64      `json:"lat"`
+	Longitude    float64      `json:"lon"`
+	Altitude     int32        `json:"alt"`
+	GroundSpeed  float64      `json:"gs"`
+	Track        float64      `json:"track"`
+	Airspeed     int32        `json:"airspeed"`
+	AirspeedTrue bool         `json:"airspeedTrue"`
+	Heading      float64      `json:"heading"`
+	VertRate     int32        `json:"vertRate"`
+	Squawk       uint16       `json:"squawk"`
+	Emergency    uint8        `json:"emergency"`
+	Category     uint8        `json:"category"`
+	ADSBVersion  uint8        `json:"adsbVersion"`
+	Source       modes.Source `json:"source"`
+	MLAT         bool         `json:"mlat"`
+	OnGround     bool         `json:"onGround"`
+	LastPing     time.Time    `json:"lastPing"`
+	LastPos      time.Time    `json:"lastPos"`
+	LastSquitter time.Time    `json:"lastSquitter"`
+}
+
+func saveAircraft(aircraft *aircraftData) savedAircraft {
+	return savedAircraft{
+		ICAOAddr:     aircraft.icaoAddr,
+		Callsign:     aircraft.callsign,
+		Latitude:     aircraft.latitude,
+		Longitude:    aircraft.longitude,
+		Altitude:     aircraft.altitude,
+		GroundSpeed:  aircraft.groundSpeed,
+		Track:        aircraft.track,
+		Airspeed:     aircraft.airspeed,
+		AirspeedTrue: aircraft.airspeedTrue,
+		Heading:      aircraft.heading,
+		VertRate:     aircraft.vertRate,
+		Squawk:       aircraft.squawk,
+		Emergency:    aircraft.emergency,
+		Category:     aircraft.category,
+		ADSBVersion:  aircraft.adsbVersion,
+		Source:       aircraft.source,
+		MLAT:         aircraft.mlat,
+		OnGround:     aircraft.onGround,
+		LastPing:     aircraft.lastPing,
+		LastPos:      aircraft.lastPos,
+		LastSquitter: aircraft.lastSquitter,
+	}
+}
+
+// restore copies a saved aircraft onto a fresh record, working out its
+// distance again in case the receiver has moved
+func (saved savedAircraft) restore(aircraft *aircraftData) {
+	aircraft.callsign = saved.Callsign
+	aircraft.setPosition(saved.Latitude, saved.Longitude)
+	aircraft.altitude = saved.Altitude
+	aircraft.groundSpeed = saved.GroundSpeed
+	aircraft.track = saved.Track
+	aircraft.airspeed = saved.Airspeed
+	aircraft.airspeedTrue = saved.AirspeedTrue
+	aircraft.heading = saved.Heading
+	aircraft.vertRate = saved.VertRate
+	aircraft.squawk = saved.Squawk
+	aircraft.emergency = saved.Emergency
+	aircraft.category = saved.Category
+	aircraft.adsbVersion = saved.ADSBVersion
+	aircraft.source = saved.Source
+	aircraft.sourceUpdated = saved.LastPing
+	aircraft.mlat = saved.MLAT
+	aircraft.onGround = saved.OnGround
+	aircraft.lastPing = saved.LastPing
+	aircraft.lastPos = saved.LastPos
+	aircraft.lastSquitter = saved.LastSquitter
+}
+
+// saveState writes the aircraft and who we have notified about to path. The
+// state goes to a temporary file that is renamed over the old one, so a crash
+// part way through leaves the last good snapshot in place.
+func saveState(path string, knownAircraft *KnownAircraft, tweetedAircraft *TweetedAircraft,
+	emergencyAircraft *TweetedAircraft) error {
+	state := trackerState{
+		Version:     stateVersion,
+		Saved:       time.Now(),
+		Tweeted:     tweetedAircraft.entries(),
+		Emergencies: emergencyAircraft.entries(),
+	}
+	for _, aircraft := range knownAircraft.sortedAircraft() {
+		state.Aircraft = append(state.Aircraft, saveAircraft(aircraft))
+	}
+
+	b, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+
+	dir, base := filepath.Split(path)
+	if dir == "" {
+		dir = "."
+	}
+	tmp, err := os.CreateTemp(dir, base+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err = tmp.Write(b); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	if err = os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+
+	// Make the rename itself durable, not every filesystem supports this
+	if d, err := os.Open(dir); err == nil {
+		_ = d.Sync()
+		d.Close()
+	}
+	return nil
+}
+
+// loadState restores what saveState wrote. Aircraft and tweets older than
+// cleanup are dropped, as they would have been had we kept running.
+// Emergency alerts have a cooldown of their own and are kept for that long
+// instead, so a restart doesn't repeat them. A missing file isn't an error.
+func loadState(path string, now time.Time, cleanup time.Duration, emergencyCooldown time.Duration,
+	knownAircraft *KnownAircraft, tweetedAircraft *TweetedAircraft, emergencyAircraft *TweetedAircraft) error {
+	b, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var state trackerState
+	if err = json.Unmarshal(b, &state); err != nil {
+		return err
+	}
+	if state.Version != stateVersion {
+		return nil
+	}
+
+	for _, saved := range state.Aircraft {
+		if now.Sub(saved.LastPing) > cleanup {
+			continue
+		}
+		knownAircraft.Update(saved.ICAOAddr, saved.restore)
+	}
+
+	tweetedAircraft.restore(state.Tweeted, now.Add(-cleanup))
+	emergencyAircraft.restore(state.Emergencies, now.Add(-emergencyCooldown))
+	return nil
+}
+
+// saveStateEvery saves the state to path until quit is closed, saving one
+// last time on the way out
+func saveStateEvery(interval time.Duration, path string, quit <-chan struct{}, knownAircraft *KnownAircraft,
+	tweetedAircraft *TweetedAircraft, emergencyAircraft *TweetedAircraft) {
+	save := func() {
+		if err := saveState(path, knownAircraft, tweetedAircraft, emergencyAircraft); err != nil {
+			log.Printf("Couldn't save state: %v\n", err)
+		}
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			save()
+		case <-quit:
+			save()
+			return
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jsmithedin/overmyhouse/pkg/modes"
+)
+
+func TestSaveAndLoadState(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "overmyhouse.state")
+	now := time.Now()
+
+	known := &KnownAircraft{}
+	known.Update(0x4840D6, func(aircraft *aircraftData) {
+		aircraft.callsign = "EZY12AB "
+		aircraft.setPosition(*baseLat+0.01, *baseLon)
+		aircraft.altitude = 3000
+		aircraft.lastPing = now.Add(-10 * time.Second)
+		aircraft.lastPos = aircraft.lastPing
+		aircraft.source = modes.SourceADSR
+	})
+	known.Update(0x40621D, func(aircraft *aircraftData) {
+		aircraft.callsign = "GONE    "
+		aircraft.lastPing = now.Add(-2 * time.Minute)
+	})
+
+	tweeted := &TweetedAircraft{tweetedMap: tweetedMap{
+		"EZY12AB ": now.Add(-10 * time.Second).Unix(),
+		"OLD     ": now.Add(-2 * time.Minute).Unix(),
+	}}
+	emergencies := &TweetedAircraft{tweetedMap: tweetedMap{
+		"4840d6 squawking 7700 (general emergency)": now.Add(-10 * time.Minute).Unix(),
+	}}
+
+	if err := saveState(path, known, tweeted, emergencies); err != nil {
+		t.Fatal(err)
+	}
+	if saved, err := os.ReadFile(path); err != nil || !strings.Contains(string(saved), `"source":"ADS-R"`) {
+		t.Fatalf("expected the source to be saved by name, got: %s %v", saved, err)
+	}
+
+	restoredKnown := &KnownAircraft{}
+	restoredTweeted := &TweetedAircraft{}
+	restoredEmergencies := &TweetedAircraft{}
+	err := loadState(path, now, 60*time.Second, 30*time.Minute, restoredKnown, restoredTweeted, restoredEmergencies)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if restoredKnown.getNumberOfKnown() != 1 {
+		t.Fatalf("expected: 1, got: %v", restoredKnown.getNumberOfKnown())
+	}
+	aircraft, ok := restoredKnown.getAircraft(0x4840D6)
+	if !ok {
+		t.Fatalf("expected 4840d6 to be restored")
+	}
+	if aircraft.callsign != "EZY12AB " || aircraft.altitude != 3000 || aircraft.source != modes.SourceADSR {
+		t.Fatalf("expected EZY12AB at 3000 from ADS-R, got: %q at %v from %v", aircraft.callsign, aircraft.altitude,
+			aircraft.source)
+	}
+	if aircraft.distance > 1200 || aircraft.eRawLat != newAircraftData(0, false).eRawLat {
+		t.Fatalf("expected a position about 1 km away and no CPR halves, got: %v %v", aircraft.distance, aircraft.eRawLat)
+	}
+
+	if !restoredTweeted.alreadyTweeted("EZY12AB ") || restoredTweeted.alreadyTweeted("OLD     ") {
+		t.Fatalf("expected only the recent tweet, got: %v", restoredTweeted.entries())
+	}
+	if restoredEmergencies.getNumberOfTweeted() != 1 {
+		t.Fatalf("expected the emergency alert to outlast the cleanup timeout, got: %v", restoredEmergencies.entries())
+	}
+}
+
+func TestSaveStateReplacesAtomically(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "overmyhouse.state")
+
+	// A snapshot torn by a crash is left as a temporary file, never as the state
+	if err := os.WriteFile(filepath.Join(dir, "overmyhouse.state.123.tmp"), []byte(`{"version":1,"airc`), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	known := &KnownAircraft{}
+	known.Update(0x4840D6, func(aircraft *aircraftData) { aircraft.lastPing = time.Now() })
+	for i := 0; i < 2; i++ {
+		if err := saveState(path, known, &TweetedAircraft{}, &TweetedAircraft{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 {
+		t.Fatalf("expected the state and the torn file, got: %v", files)
+	}
+
+	restored := &KnownAircraft{}
+	if err = loadState(path, time.Now(), time.Minute, time.Minute, restored, &TweetedAircraft{}, &TweetedAircraft{}); err != nil {
+		t.Fatal(err)
+	}
+	if restored.getNumberOfKnown() != 1 {
+		t.Fatalf("expected: 1, got: %v", restored.getNumberOfKnown())
+	}
+}
+
+func TestLoadStateMissingOrCorrupt(t *testing.T) {
+	dir := t.TempDir()
+	known := &KnownAircraft{}
+
+	err := loadState(filepath.Join(dir, "missing"), time.Now(), time.Minute, time.Minute, known, &TweetedAircraft{}, &TweetedAircraft{})
+	if err != nil {
+		t.Fatalf("expected a missing file to be ignored, got: %v", err)
+	}
+
+	path := filepath.Join(dir, "corrupt")
+	if err = os.WriteFile(path, []byte("not json"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	err = loadState(path, time.Now(), time.Minute, time.Minute, known, &TweetedAircraft{}, &TweetedAircraft{})
+	if err == nil {
+		t.Fatalf("expected an error for a corrupt file")
+	}
+	if known.getNumberOfKnown() != 0 {
+		t.Fatalf("expected: 0, got: %v", known.getNumberOfKnown())
+	}
+}
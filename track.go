@@ -0,0 +1,113 @@
+package main
+
+import (
+	"math"
+	"time"
+)
+
+// TrackPoint is where an aircraft was at a moment, along with how it was
+// moving if we knew. Unknown values are set to the largest value of their
+// type as they are in aircraftData.
+type TrackPoint struct {
+	Time        time.Time
+	Latitude    float64
+	Longitude   float64
+	Altitude    int32
+	GroundSpeed float64
+	Track       float64
+	VertRate    int32
+}
+
+// trackHistory is a ring of the last *trackLength points an aircraft reported,
+// none more than *trackAge older than the latest. The ring is allocated with
+// the first point, so aircraft that never send a position cost nothing.
+type trackHistory struct {
+	points []TrackPoint
+	next   int
+	count  int
+}
+
+func (history *trackHistory) add(point TrackPoint) {
+	if *trackLength <= 0 {
+		return
+	}
+	if len(history.points) != *trackLength {
+		history.points = make([]TrackPoint, *trackLength)
+		history.next, history.count = 0, 0
+	}
+
+	// Points are added in time order, so the expired ones are the oldest
+	oldest := point.Time.Add(-time.Duration(*trackAge) * time.Second)
+	for history.count > 0 {
+		start := (history.next - history.count + len(history.points)) % len(history.points)
+		if !history.points[start].Time.Before(oldest) {
+			break
+		}
+		history.count--
+	}
+
+	history.points[history.next] = point
+	history.next = (history.next + 1) % len(history.points)
+	if history.count < len(history.points) {
+		history.count++
+	}
+}
+
+// since returns a copy of the points after the given time, oldest first.
+// Points older than *trackAge are left out whatever time is asked for, as
+// the history is only pruned when a point is added.
+func (history *trackHistory) since(since time.Time, now time.Time) []TrackPoint {
+	if oldest := now.Add(-time.Duration(*trackAge) * time.Second); oldest.After(since) {
+		since = oldest
+	}
+
+	var points []TrackPoint
+	start := history.next - history.count
+	if start < 0 {
+		start += len(history.points)
+	}
+	for i := 0; i < history.count; i++ {
+		point := history.points[(start+i)%len(history.points)]
+		if point.Time.After(since) {
+			points = append(points, point)
+		}
+	}
+	return points
+}
+
+// recordTrack adds the aircraft's current position and motion to its track
+func (aircraft *aircraftData) recordTrack(now time.Time) {
+	aircraft.history.add(TrackPoint{
+		Time:        now,
+		Latitude:    aircraft.latitude,
+		Longitude:   aircraft.longitude,
+		Altitude:    aircraft.altitude,
+		GroundSpeed: aircraft.groundSpeed,
+		Track:       aircraft.track,
+		VertRate:    aircraft.vertRate,
+	})
+}
+
+// Trail returns the points an aircraft has reported since the given time,
+// oldest first, or nil if we don't know it. Pass the zero time for all of it.
+func (kAircraft *KnownAircraft) Trail(icaoAddr uint32, since time.Time) []TrackPoint {
+	shard := kAircraft.shard(icaoAddr)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+	if aircraft, known := shard.knownMap[icaoAddr]; known {
+		return aircraft.history.since(since, time.Now())
+	}
+	return nil
+}
+
+// ClosestApproach finds the point on an aircraft's trail nearest the
+// receiver and how far away it was in meters
+func (kAircraft *KnownAircraft) ClosestApproach(icaoAddr uint32) (closest TrackPoint, distance float64, ok bool) {
+	distance = math.MaxFloat64
+	for _, point := range kAircraft.Trail(icaoAddr, time.Time{}) {
+		if pointDistance := GreatCircle(point.Latitude, point.Longitude, *baseLat, *baseLon); pointDistance < distance {
+			closest, distance, ok = point, pointDistance, true
+		}
+	}
+	return closest, distance, ok
+}
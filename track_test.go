@@ -0,0 +1,98 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func Test_trackHistory(t *testing.T) {
+	defer func(length, age int) { *trackLength, *trackAge = length, age }(*trackLength, *trackAge)
+	*trackLength, *trackAge = 3, 60
+
+	now := time.Now()
+	var history trackHistory
+	for i := 5; i > 0; i-- {
+		history.add(TrackPoint{Time: now.Add(-time.Duration(i) * 20 * time.Second), Altitude: int32(i)})
+	}
+
+	tests := []struct {
+		since     time.Time
+		altitudes []int32
+	}{
+		// The ring only holds 3 and the oldest of those is too old
+		{since: time.Time{}, altitudes: []int32{2, 1}},
+		{since: now.Add(-30 * time.Second), altitudes: []int32{1}},
+		{since: now, altitudes: nil},
+	}
+
+	for _, tc := range tests {
+		points := history.since(tc.since, now)
+		if len(points) != len(tc.altitudes) {
+			t.Fatalf("expected: %v, got: %+v", tc.altitudes, points)
+		}
+		for i, point := range points {
+			if point.Altitude != tc.altitudes[i] {
+				t.Fatalf("expected: %v, got: %+v", tc.altitudes, points)
+			}
+		}
+	}
+
+	// A point a minute on leaves the rest too old to keep
+	history.add(TrackPoint{Time: now.Add(time.Minute), Altitude: 0})
+	if history.count != 1 {
+		t.Fatalf("expected the expired points to be dropped, got: %v", history.count)
+	}
+	if points := history.since(time.Time{}, now); len(points) != 1 || points[0].Altitude != 0 {
+		t.Fatalf("expected: [0], got: %+v", points)
+	}
+
+	*trackLength = 0
+	var empty trackHistory
+	empty.add(TrackPoint{Time: now})
+	if points := empty.since(time.Time{}, now); points != nil {
+		t.Fatalf("expected no history, got: %+v", points)
+	}
+}
+
+func TestTrail(t *testing.T) {
+	testKnown = &KnownAircraft{}
+	now := time.Now()
+
+	// Flies due north over the receiver
+	for i := -2; i <= 2; i++ {
+		testKnown.Update(123, func(aircraft *aircraftData) {
+			aircraft.setPosition(*baseLat+float64(i)/100, *baseLon)
+			aircraft.altitude = 3000 + int32(i)*100
+			aircraft.recordTrack(now.Add(time.Duration(i) * time.Second))
+		})
+	}
+
+	trail := testKnown.Trail(123, time.Time{})
+	if len(trail) != 5 || trail[0].Altitude != 2800 || trail[4].Altitude != 3200 {
+		t.Fatalf("expected 5 points climbing from 2800 to 3200, got: %+v", trail)
+	}
+	if trail[0].GroundSpeed != math.MaxFloat64 {
+		t.Fatalf("expected an unknown speed, got: %v", trail[0].GroundSpeed)
+	}
+
+	if trail := testKnown.Trail(123, now); len(trail) != 2 {
+		t.Fatalf("expected: 2, got: %+v", trail)
+	}
+	if trail := testKnown.Trail(456, time.Time{}); trail != nil {
+		t.Fatalf("expected no trail, got: %+v", trail)
+	}
+
+	closest, distance, ok := testKnown.ClosestApproach(123)
+	if !ok || closest.Altitude != 3000 || distance != 0 {
+		t.Fatalf("expected to pass overhead at 3000, got: %+v %v", closest, distance)
+	}
+	if _, _, ok = testKnown.ClosestApproach(456); ok {
+		t.Fatalf("expected no closest approach")
+	}
+
+	aircraft, _ := testKnown.getAircraft(123)
+	if aircraft.history.points != nil {
+		t.Fatalf("expected the snapshot to leave out the history")
+	}
+}
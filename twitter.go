@@ -45,11 +45,16 @@ func (tAircraft *TweetedAircraft) alreadyTweeted(callsign string) bool {
 }
 
 func (tAircraft *TweetedAircraft) pruneTweeted() {
+	tAircraft.pruneTweetedAfter(60)
+}
+
+// pruneTweetedAfter forgets anything tweeted more than cooldown seconds ago
+func (tAircraft *TweetedAircraft) pruneTweetedAfter(cooldown int64) {
 	tAircraft.mu.Lock()
 	timeNow := time.Now().Unix()
 
 	for callsign, timeAdded := range (*tAircraft).tweetedMap {
-		if (timeNow - timeAdded) > 60 {
+		if (timeNow - timeAdded) > cooldown {
 			delete(tAircraft.tweetedMap, callsign)
 		}
 	}
@@ -57,6 +62,31 @@ func (tAircraft *TweetedAircraft) pruneTweeted() {
 	tAircraft.mu.Unlock()
 }
 
+// entries returns a copy of everything tweeted and when
+func (tAircraft *TweetedAircraft) entries() tweetedMap {
+	tAircraft.mu.Lock()
+	defer tAircraft.mu.Unlock()
+	entries := make(tweetedMap, len(tAircraft.tweetedMap))
+	for callsign, timeAdded := range tAircraft.tweetedMap {
+		entries[callsign] = timeAdded
+	}
+	return entries
+}
+
+// restore adds back anything tweeted since the given time
+func (tAircraft *TweetedAircraft) restore(entries tweetedMap, since time.Time) {
+	tAircraft.mu.Lock()
+	defer tAircraft.mu.Unlock()
+	if tAircraft.tweetedMap == nil {
+		tAircraft.tweetedMap = make(tweetedMap)
+	}
+	for callsign, timeAdded := range entries {
+		if timeAdded >= since.Unix() {
+			tAircraft.tweetedMap[callsign] = timeAdded
+		}
+	}
+}
+
 func tweet(message string) (int64, error) {
 	err := godotenv.Load()
 	if err != nil {